@@ -6,18 +6,28 @@ import (
 	"time"
 
 	"github.com/evan-buss/openbooks/core"
+	"github.com/evan-buss/openbooks/dcc"
 	"github.com/evan-buss/openbooks/irc"
+	"github.com/evan-buss/openbooks/proxy"
 )
 
 type Config struct {
-	UserName  string // Username to use when connecting to IRC
-	Log       bool   // True if IRC messages should be logged
-	Dir       string
-	Server    string
-	EnableTLS bool
-	SearchBot string
-	Version   string
-	irc       *irc.Conn
+	UserName            string         // Username to use when connecting to IRC
+	AltNicks            []string       // Usernames to try if UserName is taken
+	NickSuffixes        int            // Number of digit suffixes to try if every name is taken
+	NickServPassword    string         // Password used to identify and reclaim UserName with NickServ
+	Auth                irc.AuthMethod // How to identify with NickServPassword
+	Account             string         // NickServ account name if it differs from UserName
+	Log                 bool           // True if IRC messages should be logged
+	Dir                 string
+	Network             core.Network   // Network profile to connect to
+	TLS                 irc.TLSOptions // Certificate verification and client certificate
+	Dialer              proxy.Dialer   // Connects to IRC and DCC, optionally through a proxy
+	DCC                 dcc.Config     // Passive DCC and transfer timeouts
+	Version             string
+	SearchAcceptTimeout time.Duration // How long a search bot may take to accept a search
+	client              *core.Client
+	ctx                 context.Context // Cancelled on shutdown to abort downloads
 }
 
 // StartInteractive instantiates the OpenBooks CLI interface
@@ -26,81 +36,54 @@ func StartInteractive(config Config) {
 	fmt.Println("          Welcome to OpenBooks         ")
 	fmt.Println("=======================================")
 
-	instantiate(&config)
-	defer config.irc.Close()
-
 	ctx, cancel := context.WithCancel(context.Background())
-	registerShutdown(config.irc, cancel)
-
-	handler := fullHandler(config)
+	config.ctx = ctx
+	instantiate(&config)
 	if config.Log {
-		file := config.setupLogger(handler)
+		file := config.setupLogger()
 		defer file.Close()
 	}
+	connect(&config, cancel)
+	defer config.client.Close()
+	registerShutdown(config.client, cancel)
 
-	go core.StartReader(ctx, config.irc, handler)
-	terminalMenu(config)
-
-	<-ctx.Done()
+	for ctx.Err() == nil {
+		terminalMenu(config)
+	}
 }
 
 func StartDownload(config Config, download string) {
-	instantiate(&config)
-	defer config.irc.Close()
 	ctx, cancel := context.WithCancel(context.Background())
-
-	handler := core.EventHandler{}
-	addEssentialHandlers(handler, &config)
-	handler[core.BookResult] = func(text string) {
-		fmt.Printf("%sReceived file response.\n", clearLine)
-		config.downloadHandler(text)
-		cancel()
-	}
+	config.ctx = ctx
+	instantiate(&config)
 	if config.Log {
-		file := config.setupLogger(handler)
+		file := config.setupLogger()
 		defer file.Close()
 	}
+	connect(&config, cancel)
+	defer config.client.Close()
+	registerShutdown(config.client, cancel)
 
-	fmt.Printf("Sending download request.")
-	go core.StartReader(ctx, config.irc, handler)
-	core.DownloadBook(config.irc, download)
-	fmt.Printf("%sSent download request.", clearLine)
-	fmt.Printf("Waiting for file response.")
-
-	registerShutdown(config.irc, cancel)
-	<-ctx.Done()
+	config.warnIfServerOffline(download)
+	fmt.Println("Sent download request. Waiting for file response.")
+	config.downloadHandler(download)
 }
 
 func StartSearch(config Config, query string) {
 	nextSearchTime := getLastSearchTime().Add(15 * time.Second)
-	instantiate(&config)
-	defer config.irc.Close()
 	ctx, cancel := context.WithCancel(context.Background())
-
-	handler := core.EventHandler{}
-	addEssentialHandlers(handler, &config)
-	handler[core.SearchResult] = func(text string) {
-		fmt.Printf("%sReceived file response.\n", clearLine)
-		config.searchHandler(text)
-		cancel()
-	}
-	handler[core.MatchesFound] = config.matchesFoundHandler
+	config.ctx = ctx
+	instantiate(&config)
 	if config.Log {
-		file := config.setupLogger(handler)
+		file := config.setupLogger()
 		defer file.Close()
 	}
+	connect(&config, cancel)
+	defer config.client.Close()
+	registerShutdown(config.client, cancel)
 
-	fmt.Printf("Sending search request.")
-	warnIfServerOffline(query)
 	time.Sleep(time.Until(nextSearchTime))
-
-	go core.StartReader(ctx, config.irc, handler)
-	core.SearchBook(config.irc, config.SearchBot, query)
-
 	setLastSearchTime()
-	fmt.Printf("%sSent search request.", clearLine)
-	fmt.Printf("Waiting for file response.")
-
-	registerShutdown(config.irc, cancel)
-	<-ctx.Done()
+	fmt.Println("Sent search request. Waiting for file response.")
+	config.searchHandler(query)
 }
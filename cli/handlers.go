@@ -1,76 +1,108 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 
 	"github.com/evan-buss/openbooks/core"
 	"github.com/evan-buss/openbooks/dcc"
 	"github.com/schollz/progressbar/v3"
 )
 
-// DownloadSearchResults downloads the search results
-// and sends user a response message
-func (c Config) searchHandler(text string) {
-	download, err := dcc.ParseString(text)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	bar := progressbar.DefaultBytes(download.Size, download.Filename)
+// searchHandler sends the query to the search bot, downloads the search
+// results and tells the user where they were saved
+func (c Config) searchHandler(query string) {
+	downloads.Add(1)
+	defer downloads.Done()
 
-	extractedPath, err := core.DownloadExtractDCCString(c.Dir, text, bar)
-	if err != nil {
+	resultsPath, bot, err := c.client.SearchFile(c.ctx, query)
+	switch {
+	case errors.Is(err, core.ErrNoResults):
+		fmt.Println("No results returned for your search...")
+	case errors.Is(err, core.ErrSearchUnanswered):
+		fmt.Println("No search bot accepted the search. Try again later...")
+	case core.Declined(err):
+		fmt.Printf("%sDeclined the search results: %v\n", clearLine, err)
+	case err != nil:
 		fmt.Println(err)
+	default:
+		fmt.Printf("Results location: %s (sent by %s)\n", resultsPath, bot)
 	}
-	fmt.Println("Results location: " + extractedPath)
 }
 
-// DownloadBookFile downloads the search results and sends
-// a user a response message
-func (c Config) downloadHandler(text string) {
-	download, err := dcc.ParseString(text)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	bar := progressbar.DefaultBytes(download.Size, download.Filename)
+// downloadHandler requests the book, downloads it and tells the user where
+// it was saved
+func (c Config) downloadHandler(book string) {
+	downloads.Add(1)
+	defer downloads.Done()
 
-	extractedPath, err := core.DownloadExtractDCCString(c.Dir, text, bar)
-	if err != nil {
+	extractedPath, integrity, err := c.client.DownloadLine(c.ctx, book)
+	switch {
+	case errors.Is(err, core.ErrServerUnavailable):
+		fmt.Println("That server is not available. Try again...")
+		return
+	case errors.Is(err, core.ErrNoResults):
+		fmt.Println("That server doesn't have the book. Try another one...")
+		return
+	case core.Declined(err):
+		fmt.Printf("%sDeclined the book: %v\n", clearLine, err)
+		return
+	case err != nil:
 		fmt.Println(err)
+		return
 	}
 	fmt.Println("File location: " + extractedPath)
+	switch integrity {
+	case core.IntegrityVerified:
+		fmt.Println("The file matches the hash listed in the search results.")
+	case core.IntegrityMismatched:
+		fmt.Println("Warning: the file doesn't match the hash listed in the search results. It may be corrupt.")
+	}
 }
 
-// NoResults is called when the user searches for something that
-// is not available sends a CLI message
-func (c Config) noResultsHandler(_ string) {
-	fmt.Println("No results returned for your search...")
-}
-
-// BadServer is called when the user tries to download a file from a
-// server that is not available.
-func (c Config) badServerHandler(_ string) {
-	fmt.Println("That server is not available. Try again...")
+// progressBar shows the progress of a transfer in the terminal.
+func progressBar(download dcc.Download) (io.Writer, func()) {
+	fmt.Print(clearLine)
+	return progressbar.DefaultBytes(download.Size, download.Filename), func() {}
 }
 
 // SearchAccepted is called when the search has been accepted but the user
 // must wait in the queue for the search to be executed.
-func (c Config) searchAcceptedHandler(_ string) {
+func (c Config) searchAcceptedHandler(_ core.SearchAccepted) {
 	fmt.Println("Search has been accepted. Please wait.")
 }
 
 // MatchesFound is called when the search returns the number of results
 // found. Server sends the client a status update
-func (c Config) matchesFoundHandler(num string) {
-	fmt.Printf("Found %s search results.", num)
+func (c Config) matchesFoundHandler(matches core.MatchesFound) {
+	fmt.Printf("Found %d search results.", matches.Count)
+}
+
+// Disconnected is called when the connection to the IRC server drops.
+func (c Config) disconnectedHandler(event core.Disconnected) {
+	fmt.Printf("%sLost connection to %s. %s\n", clearLine, c.Network.Address, event.Reason)
+}
+
+// Reconnecting is called before each attempt to restore the connection.
+func (c Config) reconnectingHandler(status core.Reconnecting) {
+	fmt.Printf("%s%s", clearLine, status)
+}
+
+// Reconnected is called once the connection has been restored. Searches that
+// were waiting for results are sent again.
+func (c Config) reconnectedHandler(event core.Reconnected) {
+	fmt.Printf("%sReconnected to %s as %s.\n", clearLine, c.Network.Address, event.Nick)
 }
 
-func (c Config) pingHandler(_ string) {
-	c.irc.Pong(c.Server)
+// searchUnansweredHandler is called when a search bot didn't accept the
+// search in time.
+func (c Config) searchUnansweredHandler(event core.SearchUnanswered) {
+	fmt.Printf("%s%s\n", clearLine, event)
 }
 
-func (c *Config) versionHandler(line string) {
-	core.SendVersionInfo(c.irc, line, c.Version)
+// offerRejectedHandler is called when a DCC offer that wasn't requested is
+// ignored.
+func (c Config) offerRejectedHandler(offer core.OfferRejected) {
+	fmt.Printf("%s%s\n", clearLine, offer)
 }
@@ -6,8 +6,6 @@ import (
 	"os"
 	"strings"
 	"time"
-
-	"github.com/evan-buss/openbooks/core"
 )
 
 func terminalMenu(config Config) {
@@ -29,52 +27,26 @@ func terminalMenu(config Config) {
 		nextSearchTime := getLastSearchTime().Add(15 * time.Second)
 		time.Sleep(time.Until(nextSearchTime))
 
-		core.SearchBook(config.irc, config.SearchBot, clean(query))
 		setLastSearchTime()
+		config.searchHandler(clean(query))
 	case "g":
 		fmt.Print("Download String: ")
 		message, _ := reader.ReadString('\n')
-		core.DownloadBook(config.irc, clean(message))
 		fmt.Println("\nSent download request.")
-		warnIfServerOffline(clean(message))
+		config.warnIfServerOffline(clean(message))
+		config.downloadHandler(clean(message))
 	case "se":
 		fmt.Println("\nAvailable Servers:")
-		for _, server := range servers {
+		for _, server := range config.servers() {
 			fmt.Printf("  %s\n", server)
 		}
 		terminalMenu(config)
 	case "d":
 		fmt.Println("Disconnecting.")
-		config.irc.Disconnect()
+		config.client.Close()
 		os.Exit(0)
 	default:
 		fmt.Println("Invalid Selection.")
 		terminalMenu(config)
 	}
 }
-
-func fullHandler(config Config) core.EventHandler {
-	handler := core.EventHandler{}
-	addEssentialHandlers(handler, &config)
-
-	handler[core.BadServer] = func(text string) {
-		config.badServerHandler(text)
-		terminalMenu(config)
-	}
-	handler[core.BookResult] = func(text string) {
-		config.downloadHandler(text)
-		terminalMenu(config)
-	}
-	handler[core.SearchResult] = func(text string) {
-		config.searchHandler(text)
-		terminalMenu(config)
-	}
-	handler[core.SearchAccepted] = config.searchAcceptedHandler
-	handler[core.NoResults] = func(text string) {
-		config.noResultsHandler(text)
-		terminalMenu(config)
-	}
-	handler[core.MatchesFound] = config.matchesFoundHandler
-
-	return handler
-}
@@ -10,6 +10,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,59 +19,106 @@ import (
 	"github.com/evan-buss/openbooks/util"
 )
 
-var servers []string
+// downloads tracks running transfers so they can clean up before exiting.
+var downloads sync.WaitGroup
 
 const clearLine = "\r\033[2K"
 
-func registerShutdown(conn *irc.Conn, cancel context.CancelFunc) {
+// serversTimeout limits how long to wait for the list of download servers.
+const serversTimeout = 10 * time.Second
+
+func registerShutdown(client *core.Client, cancel context.CancelFunc) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		conn.Disconnect()
+		// Abort running downloads and wait for them to remove their files.
 		cancel()
+		downloads.Wait()
+		client.Close()
 		os.Exit(0)
 	}()
 }
 
-// Connect to IRC server and save connection to Config
+// Create the IRC connection and client and save them to Config
 func instantiate(config *Config) {
-	fmt.Printf("Connecting to %s.", config.Server)
 	conn := irc.New(config.UserName, config.Version)
-	config.irc = conn
-	err := core.Join(conn, config.Server, config.EnableTLS)
+	conn.NickStrategy = irc.ChainNicks(irc.FallbackNicks(config.AltNicks...), irc.SuffixDigits(config.NickSuffixes))
+	conn.NickServPassword = config.NickServPassword
+	conn.Auth = config.Auth
+	conn.Account = config.Account
+	conn.TLS = config.TLS
+	conn.Dialer = config.Dialer
+
+	config.client = core.NewClient(conn, core.ClientConfig{
+		Network:             config.Network,
+		DCC:                 config.DCC,
+		Dir:                 config.Dir,
+		Version:             config.Version,
+		SearchAcceptTimeout: config.SearchAcceptTimeout,
+		Progress:            progressBar,
+	})
+	addEssentialHandlers(config.client.Events(), config)
+}
+
+// Connect to IRC server. cancel is called once the connection is gone for good.
+func connect(config *Config, cancel context.CancelFunc) {
+	fmt.Printf("Connecting to %s.", config.Network.Address)
+	err := config.client.Connect(config.ctx)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Printf("%sUnable to connect to %s. %s\n", clearLine, config.Network.Address, err)
+		var certErr *irc.CertificateError
+		if errors.As(err, &certErr) {
+			fmt.Println("Use --tls-ca or --tls-fingerprint to trust the certificate, or --tls-insecure to skip verification.")
+		}
+		os.Exit(1)
 	}
 
-	fmt.Printf("%sConnected to %s.\n", clearLine, config.Server)
+	go func() {
+		<-config.client.Done()
+		cancel()
+	}()
+	fmt.Printf("%sConnected to %s as %s.\n", clearLine, config.Network.Address, config.client.Conn().Nick())
 }
 
 // Required handlers are used regardless of what CLI mode is selected.
-// Keep alive pings and other core IRC client features
-func addEssentialHandlers(handler core.EventHandler, config *Config) {
-	handler[core.Ping] = config.pingHandler
-	handler[core.Version] = config.versionHandler
-	handler[core.ServerList] = func(text string) {
-		servers = core.ParseServers(text).ElevatedUsers
-	}
+// Status updates of searches and the connection
+func addEssentialHandlers(events *core.Events, config *Config) {
+	core.Subscribe(events, config.searchAcceptedHandler)
+	core.Subscribe(events, config.matchesFoundHandler)
+	core.Subscribe(events, config.disconnectedHandler)
+	core.Subscribe(events, config.reconnectingHandler)
+	core.Subscribe(events, config.reconnectedHandler)
+	core.Subscribe(events, config.offerRejectedHandler)
+	core.Subscribe(events, config.searchUnansweredHandler)
 }
 
-func (config *Config) setupLogger(handler core.EventHandler) io.Closer {
+func (config *Config) setupLogger() io.Closer {
 	logger, file, err := util.CreateLogFile(config.UserName, config.Dir)
 	if err != nil {
 		log.Fatalf("Error setting up logger: %s\n", err)
 	}
-	handler[core.Message] = func(text string) {
-		logger.Println(text)
-	}
+	core.Subscribe(config.client.Events(), func(msg core.RawMessage) {
+		logger.Println(msg.Line)
+	})
 
 	return file
 }
 
+// servers returns the download servers that are online.
+func (config Config) servers() []string {
+	ctx, cancel := context.WithTimeout(config.ctx, serversTimeout)
+	defer cancel()
+	servers, err := config.client.Servers(ctx)
+	if err != nil {
+		return nil
+	}
+	return servers.ElevatedUsers
+}
+
 // Show warning message if the server they are downloading from is not online.
-func warnIfServerOffline(bookLine string) {
-	for _, server := range servers {
+func (config Config) warnIfServerOffline(bookLine string) {
+	for _, server := range config.servers() {
 		if strings.HasPrefix(bookLine[1:], server) {
 			return
 		}
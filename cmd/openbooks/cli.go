@@ -32,10 +32,17 @@ var cliCmd = &cobra.Command{
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		cliConfig.Version = globalFlags.UserAgent
 		cliConfig.UserName = globalFlags.UserName
-		cliConfig.Server = globalFlags.Server
+		cliConfig.AltNicks = globalFlags.AltNicks
+		cliConfig.NickSuffixes = globalFlags.NickSuffixes
+		cliConfig.NickServPassword = globalFlags.NickServPassword
+		cliConfig.Auth = authMethod()
+		cliConfig.Account = globalFlags.Account
+		_, cliConfig.Network = selectNetwork(cmd)
 		cliConfig.Log = globalFlags.Log
-		cliConfig.SearchBot = globalFlags.SearchBot
-		cliConfig.EnableTLS = globalFlags.EnableTLS
+		cliConfig.TLS = globalFlags.TLS
+		cliConfig.Dialer = proxyDialer()
+		cliConfig.DCC = dccConfig()
+		cliConfig.SearchAcceptTimeout = globalFlags.SearchAcceptTimeout
 
 		if debug {
 			spew.Dump(cliConfig)
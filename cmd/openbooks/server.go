@@ -24,6 +24,7 @@ func init() {
 	serverCmd.Flags().BoolVarP(&openBrowser, "browser", "b", false, "Open the browser on server start.")
 	serverCmd.Flags().BoolVar(&serverConfig.Persist, "persist", false, "Persist eBooks in 'dir'. Default is to delete after sending.")
 	serverCmd.Flags().StringVarP(&serverConfig.DownloadDir, "dir", "d", filepath.Join(os.TempDir(), "openbooks"), "The directory where eBooks are saved when persist enabled.")
+	serverCmd.Flags().BoolVar(&serverConfig.AllowThrottleChanges, "allow-throttle-changes", false, "Allow every web UI visitor to change the DCC bandwidth limits with PUT /throttle.")
 }
 
 var serverCmd = &cobra.Command{
@@ -31,7 +32,7 @@ var serverCmd = &cobra.Command{
 	Short: "Run OpenBooks in server mode.",
 	Long:  "Run OpenBooks in server mode. This allows you to use a web interface to search and download eBooks.",
 	PreRun: func(cmd *cobra.Command, args []string) {
-		bindGlobalServerFlags(&serverConfig)
+		bindGlobalServerFlags(cmd, &serverConfig)
 		rateLimit, _ := cmd.Flags().GetInt("rate-limit")
 		ensureValidRate(rateLimit, &serverConfig)
 		// If cli flag isn't set (default value) check for the presence of an
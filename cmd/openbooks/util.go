@@ -1,20 +1,140 @@
 package main
 
 import (
+	"log"
+	"net"
 	"path"
+	"strings"
 	"time"
 
+	"github.com/evan-buss/openbooks/core"
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/evan-buss/openbooks/proxy"
 	"github.com/evan-buss/openbooks/server"
+	"github.com/evan-buss/openbooks/util"
+	"github.com/spf13/cobra"
 )
 
 // Update a server config struct from globalFlags
-func bindGlobalServerFlags(config *server.Config) {
+func bindGlobalServerFlags(cmd *cobra.Command, config *server.Config) {
 	config.UserAgent = globalFlags.UserAgent
 	config.UserName = globalFlags.UserName
+	config.AltNicks = globalFlags.AltNicks
+	config.NickSuffixes = globalFlags.NickSuffixes
+	config.NickServPassword = globalFlags.NickServPassword
+	config.Auth = authMethod()
+	config.Account = globalFlags.Account
 	config.Log = globalFlags.Log
-	config.Server = globalFlags.Server
-	config.SearchBot = globalFlags.SearchBot
-	config.EnableTLS = globalFlags.EnableTLS
+	networks, network := selectNetwork(cmd)
+	config.Networks = networks
+	config.Network = network.Name
+	config.TLS = globalFlags.TLS
+	config.Dialer = proxyDialer()
+	config.DCC = dccConfig()
+	config.SearchAcceptTimeout = globalFlags.SearchAcceptTimeout
+}
+
+// Convert the --auth flag to an irc.AuthMethod. Password based methods are
+// disabled when no password is provided.
+func authMethod() irc.AuthMethod {
+	method, err := irc.ParseAuthMethod(globalFlags.Auth)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if method == irc.AuthExternal {
+		if globalFlags.TLS.CertFile == "" {
+			log.Fatalln("--auth external requires a client certificate (--tls-cert and --tls-key)")
+		}
+		return method
+	}
+
+	if globalFlags.NickServPassword == "" {
+		return irc.AuthNone
+	}
+	return method
+}
+
+// Load the network profiles and select the one given by --network. --server,
+// --tls, --searchbot and --fallback-searchbot override the selected profile
+// when they are set.
+func selectNetwork(cmd *cobra.Command) (core.Networks, core.Network) {
+	networks, err := core.LoadNetworks(globalFlags.NetworksFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	network, err := networks.Get(globalFlags.Network)
+	if err != nil {
+		log.Fatalf("%s. Available networks: %s\n", err, strings.Join(networks.Names(), ", "))
+	}
+
+	if globalFlags.Server != "" {
+		network.Address = globalFlags.Server
+	}
+	if cmd.Flags().Changed("tls") {
+		network.EnableTLS = globalFlags.EnableTLS
+	}
+	if globalFlags.SearchBot != "" {
+		network.SearchBot = globalFlags.SearchBot
+	}
+	if len(globalFlags.FallbackBots) > 0 {
+		network.FallbackSearchBots = globalFlags.FallbackBots
+	}
+
+	networks[network.Name] = network
+	return networks, network
+}
+
+// Convert the --proxy flag to a proxy.Dialer.
+func proxyDialer() proxy.Dialer {
+	dialer, err := proxy.FromURL(globalFlags.Proxy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return dialer
+}
+
+// Convert the --dcc-*, --max-size and --quota flags to a dcc.Config.
+func dccConfig() dcc.Config {
+	min, max, err := dcc.ParsePortRange(globalFlags.DCCPorts)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	passive := dcc.Passive{PortMin: min, PortMax: max}
+	if globalFlags.DCCIP != "" {
+		passive.IP = net.ParseIP(globalFlags.DCCIP)
+		if passive.IP == nil {
+			log.Fatalf("--dcc-ip %q is not an IP address\n", globalFlags.DCCIP)
+		}
+	}
+	maxSize, err := util.ParseSize(globalFlags.MaxSize)
+	if err != nil {
+		log.Fatalf("--max-size: %v\n", err)
+	}
+	quota, err := util.ParseSize(globalFlags.Quota)
+	if err != nil {
+		log.Fatalf("--quota: %v\n", err)
+	}
+	rate, err := util.ParseSize(globalFlags.DCCRate)
+	if err != nil {
+		log.Fatalf("--dcc-rate: %v\n", err)
+	}
+	transferRate, err := util.ParseSize(globalFlags.DCCTransferRate)
+	if err != nil {
+		log.Fatalf("--dcc-transfer-rate: %v\n", err)
+	}
+
+	return dcc.Config{
+		Passive:      passive,
+		Timeouts:     globalFlags.DCCTimeouts,
+		AllowPrivate: globalFlags.DCCAllowPrivate,
+		MaxSize:      maxSize,
+		Quota:        quota,
+		Throttle:     dcc.NewThrottle(rate, transferRate),
+	}
 }
 
 // Make sure the server config has a valid rate limit.
@@ -25,7 +145,7 @@ func ensureValidRate(rateLimit int, config *server.Config) {
 		rateLimit = 10
 	}
 
-	config.SearchTimeout = time.Duration(rateLimit) * time.Second
+	config.SearchInterval = time.Duration(rateLimit) * time.Second
 }
 
 func sanitizePath(basepath string) string {
@@ -0,0 +1,378 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+)
+
+var (
+	ErrNoResults         = errors.New("no results found")
+	ErrServerUnavailable = errors.New("download server is not available")
+	ErrTimeout           = errors.New("no answer before the timeout")
+	ErrReaderStopped     = errors.New("irc reader stopped")
+	ErrSearchUnanswered  = errors.New("no search bot answered")
+)
+
+// DefaultSearchAcceptTimeout is how long a search bot may take to accept a
+// search before the next bot is tried.
+const DefaultSearchAcceptTimeout = time.Minute
+
+// ClientConfig configures a Client.
+type ClientConfig struct {
+	// Network is joined by Connect. Searches go to its search bot.
+	Network Network
+	// DCC configures the transfers.
+	DCC dcc.Config
+	// Dir is where search results and books are saved.
+	Dir string
+	// Version is sent in reply to CTCP VERSION requests.
+	Version string
+	// Timeout limits how long Search and Download wait for the DCC offer.
+	// Defaults to OfferTimeout. The transfer itself isn't limited.
+	Timeout time.Duration
+	// SearchAcceptTimeout is how long a search bot may take to accept the
+	// search or answer it before the network's next fallback bot is tried.
+	// Bots that were skipped may still send the results until Timeout.
+	// Defaults to DefaultSearchAcceptTimeout.
+	SearchAcceptTimeout time.Duration
+	// SearchInterval is the minimum time between two searches. Search waits
+	// until it passed.
+	SearchInterval time.Duration
+	// Progress returns a writer that receives the data of a transfer and a
+	// function that is called once it ended. Optional.
+	Progress func(download dcc.Download) (progress io.Writer, done func())
+}
+
+// Client owns an IRC connection to a book network and turns searches and
+// downloads into blocking calls. It answers pings and CTCP VERSION requests
+// itself. Subscribe to Events for status updates like MatchesFound.
+type Client struct {
+	config ClientConfig
+	conn   *irc.Conn
+	events *Events
+
+	// Searches are sent one at a time.
+	searchMutex sync.Mutex
+	lastSearch  time.Time
+
+	mutex   sync.Mutex
+	servers *IrcServers
+	// listed is closed once the first server list arrived.
+	listed chan struct{}
+	// done is closed once the reader stopped and err is set.
+	done chan struct{}
+	err  error
+}
+
+// NewClient returns a client for the connection. Call Connect to join the
+// network.
+func NewClient(conn *irc.Conn, config ClientConfig) *Client {
+	if config.Timeout <= 0 {
+		config.Timeout = OfferTimeout
+	}
+	if config.SearchAcceptTimeout <= 0 {
+		config.SearchAcceptTimeout = DefaultSearchAcceptTimeout
+	}
+
+	c := &Client{
+		config: config,
+		conn:   conn,
+		events: &Events{},
+		listed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	Subscribe(c.events, func(ping Ping) { conn.Pong(context.Background(), ping.Token) })
+	Subscribe(c.events, func(request VersionRequest) {
+		SendVersionInfo(context.Background(), conn, request.From, config.Version)
+	})
+	Subscribe(c.events, c.serverList)
+	return c
+}
+
+// Conn returns the client's IRC connection.
+func (c *Client) Conn() *irc.Conn {
+	return c.conn
+}
+
+// Events returns the events of the client's reader. Subscribe before
+// calling Connect to receive every event.
+func (c *Client) Events() *Events {
+	return c.events
+}
+
+// Network returns the network the client joins.
+func (c *Client) Network() Network {
+	return c.config.Network
+}
+
+// Connect joins the network and starts reading messages until the context
+// is cancelled or Close is called.
+func (c *Client) Connect(ctx context.Context) error {
+	if err := Join(ctx, c.conn, c.config.Network); err != nil {
+		return err
+	}
+
+	go func() {
+		err := StartReader(ctx, c.conn, c.events)
+		c.mutex.Lock()
+		c.err = err
+		c.mutex.Unlock()
+		close(c.done)
+	}()
+	return nil
+}
+
+// Close disconnects from the network. Pending calls fail.
+func (c *Client) Close() {
+	c.conn.Disconnect()
+}
+
+// Done is closed once the client stopped reading messages.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the reader stopped. It is nil if the reader is
+// still running or was stopped by the context or Close.
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// SearchResult holds the parsed results of a search.
+type SearchResult struct {
+	// Bot is the nick of the search bot that sent the results.
+	Bot    string
+	Books  []BookDetail
+	Errors []ParseError
+}
+
+// Search sends the query to the search bot and returns the parsed results.
+// The results file is removed.
+func (c *Client) Search(ctx context.Context, query string) (SearchResult, error) {
+	path, bot, err := c.SearchFile(ctx, query)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	defer os.Remove(path)
+
+	books, parseErrors, err := ParseSearchFile(path)
+	return SearchResult{Bot: bot, Books: books, Errors: parseErrors}, err
+}
+
+// SearchFile sends the query to the search bot and returns the path of the
+// downloaded results file and the nick of the bot that sent it. If the bot
+// doesn't accept the search before the accept timeout, the query is sent to
+// the network's fallback bots in order. The first results that arrive are
+// used, even from a bot that was skipped. The hashes the file lists are
+// remembered to verify the books downloaded later.
+func (c *Client) SearchFile(ctx context.Context, query string) (string, string, error) {
+	c.searchMutex.Lock()
+	defer c.searchMutex.Unlock()
+
+	bots := c.config.Network.searchBots()
+	results := make(chan waitResult, len(bots))
+	stop := make(chan struct{})
+	defer close(stop)
+
+	var asked []*request
+	defer func() {
+		for _, r := range asked {
+			sessionFor(c.conn).cancelOffer(r)
+		}
+	}()
+
+	var offer Offer
+	var err error
+	for i, bot := range bots {
+		r, sendErr := c.searchBot(ctx, bot, query)
+		if sendErr != nil {
+			return "", "", sendErr
+		}
+		asked = append(asked, r)
+		go forward(r, results, stop)
+
+		offer, err = c.wait(ctx, results, r, c.config.SearchAcceptTimeout)
+		if !errors.Is(err, ErrSearchUnanswered) {
+			break
+		}
+
+		unanswered := SearchUnanswered{Bot: bot, Query: query}
+		if i+1 < len(bots) {
+			unanswered.Next = bots[i+1]
+		}
+		c.events.publish(unanswered)
+	}
+
+	if errors.Is(err, ErrSearchUnanswered) {
+		// Some bots send the results without accepting the search first.
+		// Every bot asked may still answer until the offer timeout.
+		offer, err = c.wait(ctx, results, asked[len(asked)-1], 0)
+		if errors.Is(err, ErrTimeout) && !anyAcknowledged(asked) {
+			err = ErrSearchUnanswered
+		}
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	path, _, err := c.download(ctx, offer)
+	if err != nil {
+		return "", "", err
+	}
+	if books, _, err := ParseSearchFile(path); err == nil {
+		RememberHashes(c.conn, books)
+	}
+	return path, offer.Sender, nil
+}
+
+// searchBot sends the query to one search bot and returns the request that
+// receives the offer of the results. The search mutex must be held.
+func (c *Client) searchBot(ctx context.Context, bot string, query string) (*request, error) {
+	if wait := time.Until(c.lastSearch.Add(c.config.SearchInterval)); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	network := c.config.Network
+	network.SearchBot = bot
+	r, err := searchBook(ctx, c.conn, network, query, c.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.lastSearch = time.Now()
+	return r, nil
+}
+
+// forward passes the result of the request on to results until stop is
+// closed. results must have room for the results of all forwarded requests.
+func forward(r *request, results chan<- waitResult, stop <-chan struct{}) {
+	select {
+	case result := <-r.result:
+		results <- result
+	case <-stop:
+	}
+}
+
+// Download requests the book from its server and returns the path of the
+// downloaded file. The file is checked against the hash listed in the
+// search results.
+func (c *Client) Download(ctx context.Context, book BookDetail) (string, Integrity, error) {
+	if book.Hash != "" {
+		sessionFor(c.conn).rememberHash(book.Full, book.Hash)
+	}
+	return c.DownloadLine(ctx, book.Full)
+}
+
+// DownloadLine requests a book with a line copied from the search results,
+// like "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub".
+func (c *Client) DownloadLine(ctx context.Context, line string) (string, Integrity, error) {
+	r, err := downloadBook(ctx, c.conn, line, c.config.Timeout)
+	if err != nil {
+		return "", IntegrityNotCheckable, err
+	}
+	defer sessionFor(c.conn).cancelOffer(r)
+
+	offer, err := c.wait(ctx, r.result, r, 0)
+	if err != nil {
+		return "", IntegrityNotCheckable, err
+	}
+	return c.download(ctx, offer)
+}
+
+// Servers returns the users of the joined channels. It waits for the list
+// if it hasn't been received yet. Once the reader stopped, the last list is
+// returned.
+func (c *Client) Servers(ctx context.Context) (IrcServers, error) {
+	select {
+	case <-c.listed:
+	case <-c.done:
+	case <-ctx.Done():
+		return IrcServers{}, ctx.Err()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.servers == nil {
+		return IrcServers{}, ErrReaderStopped
+	}
+	return *c.servers, nil
+}
+
+func (c *Client) serverList(list ServerList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.servers == nil {
+		close(c.listed)
+	}
+	c.servers = &list.IrcServers
+}
+
+// wait blocks until a result arrives, the request expired or the reader
+// stopped. If acceptTimeout is set, a search that the bot neither accepted
+// nor answered by then fails with ErrSearchUnanswered.
+func (c *Client) wait(ctx context.Context, results <-chan waitResult, r *request, acceptTimeout time.Duration) (Offer, error) {
+	timeout := time.NewTimer(time.Until(r.expires))
+	defer timeout.Stop()
+
+	var unanswered <-chan time.Time
+	acknowledged := r.acknowledged
+	if acceptTimeout > 0 {
+		timer := time.NewTimer(acceptTimeout)
+		defer timer.Stop()
+		unanswered = timer.C
+	}
+
+	for {
+		select {
+		case result := <-results:
+			return result.offer, result.err
+		case <-acknowledged:
+			unanswered, acknowledged = nil, nil
+		case <-unanswered:
+			return Offer{}, ErrSearchUnanswered
+		case <-timeout.C:
+			return Offer{}, ErrTimeout
+		case <-c.done:
+			if err := c.Err(); err != nil {
+				return Offer{}, err
+			}
+			return Offer{}, ErrReaderStopped
+		case <-ctx.Done():
+			return Offer{}, ctx.Err()
+		}
+	}
+}
+
+// anyAcknowledged returns true if a bot accepted one of the searches.
+func anyAcknowledged(requests []*request) bool {
+	for _, r := range requests {
+		select {
+		case <-r.acknowledged:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+func (c *Client) download(ctx context.Context, offer Offer) (string, Integrity, error) {
+	var progress io.Writer
+	if c.config.Progress != nil {
+		var done func()
+		progress, done = c.config.Progress(offer.Download)
+		defer done()
+	}
+	return DownloadOffer(ctx, c.conn, c.config.DCC, c.config.Dir, offer, progress)
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const searchResults = "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub ::INFO:: 358.9KB\r\n"
+
+// serveFile accepts DCC connections and sends content to each of them. It
+// returns the port to offer.
+func serveFile(t *testing.T, content string) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(content))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startBookServer answers searches and downloads like the bots on
+// IRCHighway. Downloads from "Ghost" fail and "Silent" never answers. The
+// "lazy" search bot sends the results late without accepting the search.
+func startBookServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	resultsPort := serveFile(t, searchResults)
+	bookPort := serveFile(t, "In my younger and more vulnerable years...")
+
+	go func() {
+		conn, lines := acceptAndWelcome(t, listener)
+		defer conn.Close()
+		for line := range lines {
+			switch {
+			case strings.HasPrefix(line, "JOIN "):
+				fmt.Fprint(conn, ":mock.server 353 evan_bot = #ebooks :evan_bot @Search +Oatmeal reader\r\n")
+				fmt.Fprint(conn, ":mock.server 366 evan_bot #ebooks :End of /NAMES list.\r\n")
+			case strings.Contains(line, ":@search zzzqqq"):
+				fmt.Fprint(conn, ":Search!search@ihw-1.com NOTICE evan_bot :Sorry, your search for \"zzzqqq\" returned no matches.\r\n")
+			case strings.Contains(line, ":@lazy "):
+				time.AfterFunc(300*time.Millisecond, func() {
+					fmt.Fprintf(conn, ":Lazy!lazy@ihw-4.com PRIVMSG evan_bot :\x01DCC SEND Lazy_results_for__the_great_gatsby.txt 2130706433 %d %d\x01\r\n", resultsPort, len(searchResults))
+				})
+			case strings.Contains(line, ":@search "):
+				fmt.Fprint(conn, ":Search!search@ihw-1.com NOTICE evan_bot :Your search returned 1 matches.\r\n")
+				fmt.Fprintf(conn, ":Search!search@ihw-1.com PRIVMSG evan_bot :\x01DCC SEND Search_results_for__the_great_gatsby.txt 2130706433 %d %d\x01\r\n", resultsPort, len(searchResults))
+			case strings.Contains(line, ":!Oatmeal "):
+				fmt.Fprintf(conn, ":Oatmeal!oat@ihw-2.com PRIVMSG evan_bot :\x01DCC SEND F_Scott_Fitzgerald_-_The_Great_Gatsby.epub 2130706433 %d 42\x01\r\n", bookPort)
+			case strings.Contains(line, ":!Ghost "):
+				fmt.Fprint(conn, ":Ghost!ghost@ihw-3.com NOTICE evan_bot :That server is not responding, try another server.\r\n")
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(irc.New("evan_bot", "OpenBooks"), ClientConfig{
+		Network: Network{Name: "test", Address: startBookServer(t), Channels: []string{"ebooks"}, SearchBot: "search", Trigger: "@"},
+		DCC:     dcc.Config{AllowPrivate: true},
+		Dir:     t.TempDir(),
+		Timeout: 500 * time.Millisecond,
+	})
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	servers, err := client.Servers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Oatmeal", "Search"}, servers.ElevatedUsers)
+
+	result, err := client.Search(ctx, "the great gatsby")
+	require.NoError(t, err)
+	assert.Equal(t, "Search", result.Bot)
+	assert.Empty(t, result.Errors)
+	require.Len(t, result.Books, 1)
+	assert.Equal(t, "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub", result.Books[0].Full)
+
+	_, err = client.Search(ctx, "zzzqqq")
+	assert.ErrorIs(t, err, ErrNoResults)
+
+	path, _, err := client.Download(ctx, result.Books[0])
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "In my younger and more vulnerable years...", string(content))
+
+	_, _, err = client.DownloadLine(ctx, "!Ghost The Great Gatsby.epub")
+	assert.ErrorIs(t, err, ErrServerUnavailable)
+
+	_, _, err = client.DownloadLine(ctx, "!Silent The Great Gatsby.epub")
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	client.Close()
+	select {
+	case <-client.Done():
+		assert.NoError(t, client.Err())
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader didn't stop")
+	}
+}
+
+func TestClientFallbackSearchBot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Only "search" answers.
+	client := NewClient(irc.New("evan_bot", "OpenBooks"), ClientConfig{
+		Network: Network{
+			Name:               "test",
+			Address:            startBookServer(t),
+			Channels:           []string{"ebooks"},
+			SearchBot:          "sleepy",
+			FallbackSearchBots: []string{"search"},
+			Trigger:            "@",
+		},
+		DCC:                 dcc.Config{AllowPrivate: true},
+		Dir:                 t.TempDir(),
+		Timeout:             500 * time.Millisecond,
+		SearchAcceptTimeout: 200 * time.Millisecond,
+	})
+	unanswered := make(chan SearchUnanswered, 2)
+	Subscribe(client.Events(), func(e SearchUnanswered) { unanswered <- e })
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	result, err := client.Search(ctx, "the great gatsby")
+	require.NoError(t, err)
+	assert.Equal(t, "Search", result.Bot)
+	assert.Len(t, result.Books, 1)
+	assert.Equal(t, SearchUnanswered{Bot: "sleepy", Query: "the great gatsby", Next: "search"}, receive(t, unanswered))
+
+	// Nobody answers before the offer timeout.
+	client.config.Network.FallbackSearchBots = nil
+	_, err = client.Search(ctx, "the great gatsby")
+	assert.ErrorIs(t, err, ErrSearchUnanswered)
+	assert.Equal(t, SearchUnanswered{Bot: "sleepy", Query: "the great gatsby"}, receive(t, unanswered))
+}
+
+func TestClientSkippedSearchBotAnswers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// "lazy" answers after it was skipped, "sleepy" never does.
+	client := NewClient(irc.New("evan_bot", "OpenBooks"), ClientConfig{
+		Network: Network{
+			Name:               "test",
+			Address:            startBookServer(t),
+			Channels:           []string{"ebooks"},
+			SearchBot:          "lazy",
+			FallbackSearchBots: []string{"sleepy"},
+			Trigger:            "@",
+		},
+		DCC:                 dcc.Config{AllowPrivate: true},
+		Dir:                 t.TempDir(),
+		Timeout:             5 * time.Second,
+		SearchAcceptTimeout: 100 * time.Millisecond,
+	})
+	unanswered := make(chan SearchUnanswered, 2)
+	Subscribe(client.Events(), func(e SearchUnanswered) { unanswered <- e })
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	result, err := client.Search(ctx, "the great gatsby")
+	require.NoError(t, err)
+	assert.Equal(t, "Lazy", result.Bot)
+	assert.Len(t, result.Books, 1)
+	assert.Equal(t, SearchUnanswered{Bot: "lazy", Query: "the great gatsby", Next: "sleepy"}, receive(t, unanswered))
+	assert.Equal(t, SearchUnanswered{Bot: "sleepy", Query: "the great gatsby"}, receive(t, unanswered))
+}
+
+func TestClientCloseDuringSearch(t *testing.T) {
+	// Run with -race. Unanswered searches are published while the reader
+	// shuts down.
+	for i := 0; i < 10; i++ {
+		client := NewClient(irc.New("evan_bot", "OpenBooks"), ClientConfig{
+			Network: Network{
+				Name:               "test",
+				Address:            startBookServer(t),
+				Channels:           []string{"ebooks"},
+				SearchBot:          "sleepy",
+				FallbackSearchBots: []string{"drowsy", "dozy"},
+				Trigger:            "@",
+			},
+			Dir:                 t.TempDir(),
+			SearchAcceptTimeout: time.Duration(i+1) * time.Millisecond,
+		})
+		Subscribe(client.Events(), func(e SearchUnanswered) {})
+		require.NoError(t, client.Connect(context.Background()))
+
+		searched := make(chan error, 1)
+		go func() {
+			_, err := client.Search(context.Background(), "the great gatsby")
+			searched <- err
+		}()
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		client.Close()
+
+		assert.Error(t, receive(t, searched))
+		select {
+		case <-client.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("reader didn't stop")
+		}
+	}
+}
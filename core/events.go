@@ -0,0 +1,373 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/evan-buss/openbooks/dcc"
+)
+
+// Defaults used when the Events fields are zero.
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 64
+)
+
+// Event is a message from the IRC server or a change of the connection
+// state. StartReader publishes them to the handlers registered with Subscribe.
+type Event interface {
+	event()
+}
+
+// transfer is implemented by the events whose handlers download files. They
+// run on the worker pool instead of blocking the events queued behind them.
+type transfer interface {
+	transfer()
+}
+
+// RawMessage is every line received from the server. Its handlers are called
+// before the line is classified, in the order the lines arrive.
+type RawMessage struct {
+	Line string
+}
+
+// Offer is a DCC SEND offer that matches a pending search or download.
+type Offer struct {
+	// Sender is the nick that sent the offer.
+	Sender string
+	// Line is the raw IRC message.
+	Line     string
+	Download dcc.Download
+}
+
+func (Offer) transfer() {}
+
+// SearchResultOffer offers the file with the results of a search.
+type SearchResultOffer struct {
+	Offer
+}
+
+// BookOffer offers a requested book.
+type BookOffer struct {
+	Offer
+}
+
+// NoResults is sent by the search bot or a download server when nothing was
+// found.
+type NoResults struct {
+	From string
+	Text string
+}
+
+// BadServer is sent when the requested download server isn't available.
+type BadServer struct {
+	From string
+	Text string
+}
+
+// SearchAccepted is sent when a search was queued by the search bot.
+type SearchAccepted struct {
+	From string
+	Text string
+}
+
+// MatchesFound is the number of matches the search bot found.
+type MatchesFound struct {
+	From  string
+	Count int
+}
+
+// Notice is a notice from another user that isn't one of the known status
+// updates.
+type Notice struct {
+	From string
+	Text string
+}
+
+// ServerList holds the users of the joined channels. It is published when
+// the lists were received and whenever they change.
+type ServerList struct {
+	IrcServers
+}
+
+// Ping must be answered with a PONG of the same token.
+type Ping struct {
+	Token string
+}
+
+// VersionRequest is a CTCP VERSION request. Answer it with SendVersionInfo.
+type VersionRequest struct {
+	From string
+}
+
+// Disconnected is published when the connection drops and when reconnecting
+// failed for good.
+type Disconnected struct {
+	Reason string
+	// Err is the read or reconnect error. Nil if the server closed the
+	// connection.
+	Err error
+}
+
+// Reconnecting is published before each attempt to restore the connection.
+type Reconnecting struct {
+	Attempt int
+	Delay   time.Duration
+}
+
+func (r Reconnecting) String() string {
+	return fmt.Sprintf("Reconnecting in %s (attempt %d).", r.Delay.Round(time.Second), r.Attempt)
+}
+
+// Reconnected is published once the connection has been restored. Searches
+// that were waiting for results are sent again.
+type Reconnected struct {
+	Nick string
+}
+
+// OfferRejected is a DCC offer that is ignored because it doesn't match a
+// pending search or download or can't be parsed.
+type OfferRejected struct {
+	Sender string
+	Line   string
+	// Filename is empty if the offer couldn't be parsed.
+	Filename string
+	Reason   string
+}
+
+func (o OfferRejected) String() string {
+	filename := "a file"
+	if o.Filename != "" {
+		filename = fmt.Sprintf("%q", o.Filename)
+	}
+	return fmt.Sprintf("Rejected DCC offer of %s from %s. %s", filename, o.Sender, o.Reason)
+}
+
+// MalformedMessage is a line that isn't a valid IRC message. It is skipped.
+type MalformedMessage struct {
+	Line string
+	Err  error
+}
+
+// ReaderStopped is the last event published by StartReader. Err is nil if
+// the context was cancelled or the connection was closed with Disconnect.
+type ReaderStopped struct {
+	Err error
+}
+
+// SearchUnanswered is published by Client when a search bot didn't accept
+// the search before the accept timeout. Next is the fallback bot the query is
+// sent to and empty if there is none left. The results of skipped bots are
+// still accepted until the offer timeout.
+type SearchUnanswered struct {
+	Bot   string
+	Query string
+	Next  string
+}
+
+func (s SearchUnanswered) String() string {
+	if s.Next == "" {
+		return fmt.Sprintf("%s didn't answer the search for %q.", s.Bot, s.Query)
+	}
+	return fmt.Sprintf("%s didn't answer the search for %q. Trying %s.", s.Bot, s.Query, s.Next)
+}
+
+func (RawMessage) event()        {}
+func (SearchResultOffer) event() {}
+func (BookOffer) event()         {}
+func (NoResults) event()         {}
+func (BadServer) event()         {}
+func (SearchAccepted) event()    {}
+func (MatchesFound) event()      {}
+func (Notice) event()            {}
+func (ServerList) event()        {}
+func (Ping) event()              {}
+func (VersionRequest) event()    {}
+func (Disconnected) event()      {}
+func (Reconnecting) event()      {}
+func (Reconnected) event()       {}
+func (OfferRejected) event()     {}
+func (MalformedMessage) event()  {}
+func (ReaderStopped) event()     {}
+func (SearchUnanswered) event()  {}
+
+// Events holds the handlers subscribed to each event type and delivers the
+// events published by StartReader. The zero value is ready to use and
+// handlers can be added and removed while the reader runs. An Events must
+// only be used by one reader at a time. Client publishes its own events too;
+// events published after the reader stopped are dropped.
+//
+// Events of the same type are handled in the order they arrive, one after
+// the other. Events of different types don't wait for each other. Handlers
+// of offers run on a pool of Workers goroutines; they start in order but may
+// finish in any order. Up to QueueSize events of each type wait for their
+// handlers. Once a queue is full, the reader stops reading until there is
+// room again.
+type Events struct {
+	// Workers limits how many offer handlers run at the same time.
+	// Defaults to DefaultWorkers.
+	Workers int
+	// QueueSize is how many events of one type can wait for their handlers.
+	// Defaults to DefaultQueueSize.
+	QueueSize int
+
+	mutex    sync.RWMutex
+	handlers map[reflect.Type][]*subscription
+
+	queueMutex sync.Mutex
+	queues     map[reflect.Type]chan Event
+	slots      chan struct{}
+	// stopped is set once the reader is done, until the next one starts.
+	stopped bool
+	// sending counts the publishers that are sending to a queue. The queues
+	// are closed once they are done.
+	sending sync.WaitGroup
+}
+
+type subscription struct {
+	handle func(Event)
+}
+
+// Subscribe calls handler with every event of type E. Any number of handlers
+// can subscribe to the same type. Call the returned function to unsubscribe.
+func Subscribe[E Event](events *Events, handler func(E)) (unsubscribe func()) {
+	key := reflect.TypeOf((*E)(nil)).Elem()
+	sub := &subscription{handle: func(event Event) { handler(event.(E)) }}
+
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	if events.handlers == nil {
+		events.handlers = make(map[reflect.Type][]*subscription)
+	}
+	events.handlers[key] = append(events.handlers[key], sub)
+
+	return func() {
+		events.mutex.Lock()
+		defer events.mutex.Unlock()
+		subs := events.handlers[key]
+		for i, s := range subs {
+			if s == sub {
+				events.handlers[key] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// subscribers returns the handlers of the event's type.
+func (e *Events) subscribers(event Event) []*subscription {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.handlers[reflect.TypeOf(event)]
+}
+
+// publish queues the event for its handlers. Raw messages are handled before
+// publish returns.
+func (e *Events) publish(event Event) {
+	if e == nil || len(e.subscribers(event)) == 0 {
+		return
+	}
+	if _, raw := event.(RawMessage); raw {
+		for _, sub := range e.subscribers(event) {
+			sub.handle(event)
+		}
+		return
+	}
+
+	// The send happens outside of the mutex. A full queue must not keep
+	// other publishers or stop waiting.
+	e.queueMutex.Lock()
+	if e.stopped {
+		e.queueMutex.Unlock()
+		return
+	}
+	queue := e.queue(event)
+	e.sending.Add(1)
+	e.queueMutex.Unlock()
+
+	defer e.sending.Done()
+	queue <- event
+}
+
+// queue returns the queue of the event's type. The goroutine that delivers
+// its events is started with it. The queue mutex must be held.
+func (e *Events) queue(event Event) chan Event {
+	key := reflect.TypeOf(event)
+	if queue, ok := e.queues[key]; ok {
+		return queue
+	}
+
+	if e.queues == nil {
+		e.queues = make(map[reflect.Type]chan Event)
+	}
+	if e.slots == nil {
+		workers := e.Workers
+		if workers <= 0 {
+			workers = DefaultWorkers
+		}
+		e.slots = make(chan struct{}, workers)
+	}
+	size := e.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	queue := make(chan Event, size)
+	e.queues[key] = queue
+	go e.deliver(queue, e.slots)
+	return queue
+}
+
+// deliver calls the handlers of each queued event until the queue is closed.
+func (e *Events) deliver(queue chan Event, slots chan struct{}) {
+	for event := range queue {
+		_, heavy := event.(transfer)
+		for _, sub := range e.subscribers(event) {
+			if !heavy {
+				sub.handle(event)
+				continue
+			}
+
+			// Wait for a free worker. Meanwhile the queue fills up.
+			slots <- struct{}{}
+			go func(sub *subscription, event Event) {
+				defer func() { <-slots }()
+				sub.handle(event)
+			}(sub, event)
+		}
+	}
+}
+
+// start allows publishing again once a new reader starts.
+func (e *Events) start() {
+	if e == nil {
+		return
+	}
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	e.stopped = false
+}
+
+// stop closes the queues once the reader is done. Queued events are still
+// delivered. Events published later are dropped until start is called.
+func (e *Events) stop() {
+	if e == nil {
+		return
+	}
+	e.queueMutex.Lock()
+	e.stopped = true
+	e.queueMutex.Unlock()
+
+	// No publisher adds to sending anymore. Wait for the ones that do.
+	e.sending.Wait()
+
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	for _, queue := range e.queues {
+		close(queue)
+	}
+	e.queues = nil
+	e.slots = nil
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	events := &Events{}
+	first := make(chan MatchesFound, 1)
+	second := make(chan MatchesFound, 1)
+	pings := make(chan Ping, 1)
+
+	Subscribe(events, func(e MatchesFound) { first <- e })
+	unsubscribe := Subscribe(events, func(e MatchesFound) { second <- e })
+	Subscribe(events, func(e Ping) { pings <- e })
+
+	events.publish(MatchesFound{From: "Search", Count: 27})
+	assert.Equal(t, MatchesFound{From: "Search", Count: 27}, receive(t, first))
+	assert.Equal(t, MatchesFound{From: "Search", Count: 27}, receive(t, second))
+	assert.Empty(t, pings)
+
+	unsubscribe()
+	events.publish(MatchesFound{From: "Search", Count: 3})
+	assert.Equal(t, MatchesFound{From: "Search", Count: 3}, receive(t, first))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, second)
+
+	// Raw messages are handled before publish returns.
+	var lines []string
+	Subscribe(events, func(e RawMessage) { lines = append(lines, e.Line) })
+	events.publish(RawMessage{Line: "PING :irc.irchighway.net"})
+	assert.Equal(t, []string{"PING :irc.irchighway.net"}, lines)
+}
+
+func receive[E any](t *testing.T, events chan E) E {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not received")
+		var zero E
+		return zero
+	}
+}
+
+func TestEventsOrder(t *testing.T) {
+	events := &Events{QueueSize: 4}
+	counts := make(chan int, 100)
+	Subscribe(events, func(e MatchesFound) {
+		// Slow handlers must not reorder the events behind them.
+		time.Sleep(time.Millisecond)
+		counts <- e.Count
+	})
+
+	for i := 0; i < 20; i++ {
+		events.publish(MatchesFound{Count: i})
+	}
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, i, receive(t, counts))
+	}
+}
+
+func TestEventsWorkers(t *testing.T) {
+	events := &Events{Workers: 2}
+	started := make(chan string, 5)
+	release := make(chan struct{})
+	Subscribe(events, func(e BookOffer) {
+		started <- e.Download.Filename
+		<-release
+	})
+	pings := make(chan Ping, 1)
+	Subscribe(events, func(e Ping) { pings <- e })
+
+	for _, name := range []string{"a.epub", "b.epub", "c.epub"} {
+		events.publish(BookOffer{Offer{Download: dcc.Download{Filename: name}}})
+	}
+	assert.ElementsMatch(t, []string{"a.epub", "b.epub"}, []string{receive(t, started), receive(t, started)})
+
+	// The third transfer waits for a free worker. Other events don't.
+	events.publish(Ping{Token: "irc.irchighway.net"})
+	assert.Equal(t, Ping{Token: "irc.irchighway.net"}, receive(t, pings))
+	assert.Empty(t, started)
+
+	release <- struct{}{}
+	assert.Equal(t, "c.epub", receive(t, started))
+	close(release)
+}
+
+func TestEventsStop(t *testing.T) {
+	events := &Events{}
+	counts := make(chan int, 2)
+	Subscribe(events, func(e MatchesFound) { counts <- e.Count })
+
+	events.publish(MatchesFound{Count: 1})
+	events.stop()
+	assert.Equal(t, 1, receive(t, counts))
+
+	// Events published after the reader stopped are dropped.
+	events.publish(MatchesFound{Count: 2})
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, counts)
+
+	// A new reader can use the same events.
+	events.start()
+	events.publish(MatchesFound{Count: 3})
+	assert.Equal(t, 3, receive(t, counts))
+	events.stop()
+}
+
+func TestEventsPublishWhileStopping(t *testing.T) {
+	events := &Events{QueueSize: 1}
+	Subscribe(events, func(e MatchesFound) { time.Sleep(time.Millisecond) })
+
+	// Publishers outside the reader race with stop. Nothing may send on a
+	// closed queue.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				events.publish(MatchesFound{Count: j})
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	events.stop()
+	wg.Wait()
+
+	events.queueMutex.Lock()
+	defer events.queueMutex.Unlock()
+	assert.Nil(t, events.queues, "no queue was started after stop")
+}
@@ -1,53 +1,231 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/evan-buss/openbooks/proxy"
 	"github.com/evan-buss/openbooks/util"
 )
 
-func DownloadExtractDCCString(baseDir, dccStr string, progress io.Writer) (string, error) {
-	// Download the file and wait until it is completed
+// ResumeTimeout is how long a download waits for the sender to accept a
+// DCC RESUME request before it starts over from the beginning.
+var ResumeTimeout = 30 * time.Second
+
+// DownloadExtractDCCString parses the DCC SEND string and downloads the
+// offered file with DownloadOffer.
+func DownloadExtractDCCString(ctx context.Context, conn *irc.Conn, config dcc.Config, baseDir, dccStr string, progress io.Writer) (string, Integrity, error) {
 	download, err := dcc.ParseString(dccStr)
 	if err != nil {
-		return "", err
+		return "", IntegrityNotCheckable, err
+	}
+	offer := Offer{Sender: senderNick(dccStr), Line: dccStr, Download: *download}
+	return DownloadOffer(ctx, conn, config, baseDir, offer, progress)
+}
+
+// DownloadOffer downloads the offered file and extracts it if it is an
+// archive. The offered file name is sanitized and never replaces an existing
+// file in baseDir. A partial ".temp" file left by an earlier attempt is
+// continued with DCC RESUME when the sender accepts it. Passive offers are
+// accepted and the transfer is limited as configured. Offers that point to a
+// private address fail with dcc.ErrPrivateAddress unless the config allows
+// them. Files larger than the configured maximum size, the free disk space or
+// the quota are declined (see Declined). The progress writer only receives
+// the bytes of the current transfer. If it has a Resume(offset int64) method,
+// it is told where a resumed transfer starts.
+//
+// The received file is compared with the hash listed for it in the search
+// results (see RememberHashes). A mismatch isn't an error; the file is kept
+// and the returned Integrity tells the caller.
+//
+// Cancelling the context aborts the transfer and removes the partial file.
+// Files of stalled or timed out transfers are kept so they can be resumed.
+func DownloadOffer(ctx context.Context, conn *irc.Conn, config dcc.Config, baseDir string, offer Offer, progress io.Writer) (string, Integrity, error) {
+	// Every subscriber gets its own copy of the offer.
+	download := &offer.Download
+	// Passive senders connect to us, so their address doesn't matter.
+	if !download.Passive() && !config.AllowPrivate {
+		if err := checkPublicAddress(ctx, conn.Dialer, download); err != nil {
+			return "", IntegrityNotCheckable, err
+		}
+	}
+	download.Dialer = conn.Dialer
+	download.Timeouts = config.Timeouts
+	download.Throttle = config.Throttle
+	sender := offer.Sender
+
+	// The file name is chosen by the sender. Keep it inside baseDir.
+	path, err := util.ConfinedPath(baseDir, download.Filename)
+	if err != nil {
+		return "", IntegrityNotCheckable, err
 	}
+	dccPath := path + ".temp"
 
-	dccPath := filepath.Join(baseDir, download.Filename+".temp")
-	file, err := os.Create(dccPath)
+	// Decline files that don't fit before anything is transferred.
+	if err := checkStorage(config, baseDir, dccPath, download); err != nil {
+		return "", IntegrityNotCheckable, err
+	}
+	file, err := openDownload(ctx, conn, sender, download, dccPath)
 	if err != nil {
-		return "", err
+		return "", IntegrityNotCheckable, err
+	}
+	defer file.Close()
+
+	digest := newDigest(sessionFor(conn).expectedHash(sender, download.Filename))
+	if digest != nil && download.Offset > 0 {
+		// The digest covers the whole file, including the resumed part.
+		if err := digest.readPartial(dccPath, download.Offset); err != nil {
+			digest = nil
+		}
 	}
 
-	writer := io.Writer(file)
+	writers := []io.Writer{file}
+	if digest != nil {
+		writers = append(writers, digest)
+	}
 	if progress != nil {
-		writer = io.MultiWriter(file, progress)
+		// Progress reporters that support it count the resumed bytes too.
+		if resumer, ok := progress.(interface{ Resume(offset int64) }); ok && download.Offset > 0 {
+			resumer.Resume(download.Offset)
+		}
+		writers = append(writers, progress)
 	}
+	writer := io.MultiWriter(writers...)
 
 	// Download DCC data to the file
-	err = download.Download(writer)
+	if download.Passive() {
+		err = receivePassive(ctx, conn, config.Passive, sender, download, writer)
+	} else {
+		err = download.Download(ctx, writer)
+	}
 	if err != nil {
-		return "", err
+		if errors.Is(err, context.Canceled) {
+			file.Close()
+			os.Remove(dccPath)
+		}
+		return "", IntegrityNotCheckable, err
 	}
 	file.Close()
+	integrity := digest.integrity()
 	if !util.IsArchive(dccPath) {
-		return renameTempFile(dccPath), nil
+		return renameTempFile(dccPath), integrity, nil
 	}
 
 	extractedPath, err := util.ExtractArchive(dccPath)
 	if err != nil {
-		return "", err
+		return "", integrity, err
+	}
+
+	return renameTempFile(extractedPath), integrity, nil
+}
+
+// checkPublicAddress returns dcc.ErrPrivateAddress if the sender's address
+// is private. Host names are resolved and the download is pinned to the
+// resolved address, so a second lookup can't return a different one. Through
+// a proxy, host names are left for the proxy to resolve.
+func checkPublicAddress(ctx context.Context, dialer proxy.Dialer, download *dcc.Download) error {
+	if download.Hostname() {
+		if dialer != nil && dialer != proxy.Direct {
+			return nil
+		}
+
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", download.IP)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			if dcc.PrivateIP(ip) {
+				return fmt.Errorf("%w %s (%s)", dcc.ErrPrivateAddress, download.IP, ip)
+			}
+		}
+		download.IP = ips[0].String()
+		return nil
+	}
+
+	if download.PrivateAddress() {
+		return fmt.Errorf("%w %s", dcc.ErrPrivateAddress, download.IP)
+	}
+	return nil
+}
+
+// senderNick returns the nick that sent the DCC SEND string, if known.
+func senderNick(dccStr string) string {
+	msg, err := irc.ParseMessage(dccStr)
+	if err != nil {
+		return ""
+	}
+	return msg.Prefix.Nick
+}
+
+// openDownload opens the file that receives the download. If a partial file
+// exists, the sender is asked to resume the transfer and the file is opened
+// at the accepted position. Otherwise the file is truncated. Passive
+// transfers always start over.
+func openDownload(ctx context.Context, conn *irc.Conn, sender string, download *dcc.Download, path string) (*os.File, error) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == 0 || info.Size() >= download.Size || sender == "" || download.Passive() {
+		return os.Create(path)
+	}
+
+	position, ok := requestResume(ctx, conn, sender, download, info.Size())
+	if !ok {
+		return os.Create(path)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// The sender may accept an earlier position than requested.
+	if err := file.Truncate(position); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	download.Offset = position
+	return file, nil
+}
+
+// requestResume sends DCC RESUME to the sender and waits for DCC ACCEPT. It
+// returns false if the sender doesn't accept within ResumeTimeout.
+func requestResume(ctx context.Context, conn *irc.Conn, sender string, download *dcc.Download, position int64) (int64, bool) {
+	session := sessionFor(conn)
+	accepted := session.expectResume(download.Port)
+	defer session.cancelResume(download.Port)
+
+	ctx, cancel := context.WithTimeout(ctx, ResumeTimeout)
+	defer cancel()
+
+	if err := conn.SendCTCP(ctx, sender, "DCC", download.ResumeArgs(position)); err != nil {
+		return 0, false
 	}
 
-	return renameTempFile(extractedPath), nil
+	select {
+	case offset := <-accepted:
+		// Never continue after the data that is actually on disk.
+		return offset, offset <= position
+	case <-ctx.Done():
+		return 0, false
+	}
 }
 
+// renameTempFile removes the ".temp" suffix. Existing files aren't replaced;
+// the new file gets a numbered name instead.
 func renameTempFile(filePath string) string {
 	if filepath.Ext(filePath) == ".temp" {
-		newPath := filePath[:len(filePath)-len(".temp")]
+		newPath := util.UniquePath(filePath[:len(filePath)-len(".temp")])
 		os.Rename(filePath, newPath)
 		return newPath
 	}
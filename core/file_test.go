@@ -0,0 +1,155 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/evan-buss/openbooks/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadResume(t *testing.T) {
+	content := []byte("The Great Gatsby by F. Scott Fitzgerald. In my younger and more vulnerable years...")
+	partial := int64(20)
+
+	dccServer := &mock.DccServer{Port: ":6971", Reader: bytes.NewReader(content)}
+	ircServer := mock.IrcServer{Port: ":6683", DCC: dccServer}
+
+	ready := make(chan struct{}, 2)
+	go dccServer.Start(ready)
+	go ircServer.Start(ready)
+	<-ready
+	<-ready
+
+	conn := irc.New("evan_28", "evan_28")
+	require.NoError(t, conn.Connect("localhost:6683", false))
+	defer conn.Disconnect()
+	require.NoError(t, conn.Register(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go StartReader(ctx, conn, &Events{})
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "great-gatsby.txt.temp"), content[:partial], 0644))
+
+	ResumeTimeout = 5 * time.Second
+	offer := ":SearchOok!ook@only.ook PRIVMSG evan_28 :\x01DCC SEND great-gatsby.txt 2130706433 6971 " +
+		strconv.Itoa(len(content)) + "\x01"
+
+	// The hash covers the resumed part of the file too.
+	sum := sha1.Sum(content)
+	RememberHashes(conn, []BookDetail{{Full: "!SearchOok great-gatsby.txt", Hash: hex.EncodeToString(sum[:])}})
+
+	progress := new(mock.WriteCloser)
+	path, integrity, err := DownloadExtractDCCString(context.Background(), conn, dcc.Config{AllowPrivate: true}, dir, offer, progress)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "great-gatsby.txt"), path)
+	assert.Equal(t, IntegrityVerified, integrity)
+	assert.Equal(t, content[partial:], progress.Data, "only the missing bytes are transferred")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestDownloadCancelRemovesFile(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// The sender stops after the first bytes.
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("The Great"))
+		time.Sleep(5 * time.Second)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	dir := t.TempDir()
+	port := listener.Addr().(*net.TCPAddr).Port
+	offer := ":SearchOok!ook@only.ook PRIVMSG evan_28 :\x01DCC SEND great-gatsby.txt 2130706433 " + strconv.Itoa(port) + " 1184\x01"
+
+	_, _, err = DownloadExtractDCCString(ctx, irc.New("evan_28", "evan_28"), dcc.Config{AllowPrivate: true}, dir, offer, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, filepath.Join(dir, "great-gatsby.txt.temp"))
+}
+
+func TestDownloadHostileFilename(t *testing.T) {
+	content := []byte("The Great Gatsby")
+
+	cases := []struct {
+		filename string
+		expected string
+	}{
+		{"../../escape.txt", "escape.txt"},
+		{`"..\..\escape.txt"`, "escape.txt"},
+		{"/etc/passwd", "passwd"},
+		{"CON", "_CON"},
+		// An existing book isn't replaced.
+		{"great-gatsby.txt", "great-gatsby (1).txt"},
+	}
+
+	for _, c := range cases {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write(content)
+		}()
+
+		root := t.TempDir()
+		dir := filepath.Join(root, "books")
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "great-gatsby.txt"), []byte("already here"), 0644))
+
+		port := listener.Addr().(*net.TCPAddr).Port
+		offer := ":SearchOok!ook@only.ook PRIVMSG evan_28 :\x01DCC SEND " + c.filename + " 2130706433 " +
+			strconv.Itoa(port) + " " + strconv.Itoa(len(content)) + "\x01"
+
+		path, _, err := DownloadExtractDCCString(context.Background(), irc.New("evan_28", "evan_28"), dcc.Config{AllowPrivate: true}, dir, offer, nil)
+		listener.Close()
+		require.NoError(t, err, c.filename)
+		assert.Equal(t, filepath.Join(dir, c.expected), path, c.filename)
+		assert.NoFileExists(t, filepath.Join(root, "escape.txt"))
+
+		data, err := os.ReadFile(filepath.Join(dir, "great-gatsby.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "already here", string(data))
+	}
+}
+
+func TestDownloadPrivateAddress(t *testing.T) {
+	offer := ":Oatmeal!oat@ihw-2.com PRIVMSG evan_28 :\x01DCC SEND great-gatsby.epub 3232235777 2050 358887\x01"
+
+	_, _, err := DownloadExtractDCCString(context.Background(), irc.New("evan_28", "evan_28"), dcc.Config{}, t.TempDir(), offer, nil)
+	assert.ErrorIs(t, err, dcc.ErrPrivateAddress)
+}
+
+func TestDownloadPrivateHostname(t *testing.T) {
+	offer := ":Oatmeal!oat@ihw-2.com PRIVMSG evan_28 :\x01DCC SEND great-gatsby.epub localhost 2050 358887\x01"
+
+	_, _, err := DownloadExtractDCCString(context.Background(), irc.New("evan_28", "evan_28"), dcc.Config{}, t.TempDir(), offer, nil)
+	assert.ErrorIs(t, err, dcc.ErrPrivateAddress)
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/evan-buss/openbooks/irc"
+)
+
+// Integrity is the result of comparing a download with the hash listed in
+// the search results.
+type Integrity string
+
+const (
+	// IntegrityVerified means the digest of the file matches the hash.
+	IntegrityVerified Integrity = "verified"
+	// IntegrityMismatched means the file differs from the one that was
+	// listed. It is likely corrupt or incomplete.
+	IntegrityMismatched Integrity = "mismatched"
+	// IntegrityNotCheckable means no hash was listed for the file or the
+	// algorithm of the hash is unknown.
+	IntegrityNotCheckable Integrity = "not checkable"
+)
+
+// maxHashes limits how many hashes a session remembers.
+const maxHashes = 10000
+
+// Bots don't say which algorithm their hashes use. The length of the hash
+// tells the candidates apart. Hashes of other lengths are compared with the
+// start of every digest. If none matches, the algorithm is unknown and the
+// file can't be checked.
+var hashesByLength = map[int][]string{
+	8:  {"crc32"},
+	32: {"md5"},
+	40: {"sha1"},
+	64: {"sha256"},
+}
+
+// RememberHashes records the hashes of search results. A later download of
+// one of the books is verified against them.
+func RememberHashes(conn *irc.Conn, books []BookDetail) {
+	session := sessionFor(conn)
+	for _, book := range books {
+		if book.Hash != "" {
+			session.rememberHash(book.Full, book.Hash)
+		}
+	}
+}
+
+// bookFilename returns the file name of a download command like
+// "!Server Author - Title.epub".
+func bookFilename(full string) string {
+	_, filename, _ := strings.Cut(full, " ")
+	return filename
+}
+
+// hashKey identifies a file on a server. Servers may list the same file name
+// with different hashes. File names are normalized because some bots
+// replace the spaces of file names with underscores when they send them.
+func hashKey(server, filename string) string {
+	return strings.ToLower(server) + " " + strings.ToLower(strings.ReplaceAll(filename, "_", " "))
+}
+
+// rememberHash records the hash of a download command like
+// "!Server Author - Title.epub".
+func (s *session) rememberHash(book, hash string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.hashes == nil || len(s.hashes) >= maxHashes {
+		s.hashes = make(map[string]string)
+	}
+	s.hashes[hashKey(downloadServer(book), bookFilename(book))] = strings.ToLower(hash)
+}
+
+// expectedHash returns the hash listed for the file the server sends.
+func (s *session) expectedHash(server, filename string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hashes[hashKey(server, filename)]
+}
+
+// digest computes the digests that can match an expected hash while the file
+// is written.
+type digest struct {
+	expected string
+	// known is set if the length of the expected hash tells the algorithm.
+	known  bool
+	hashes map[string]hash.Hash
+}
+
+// newDigest returns nil if there is no expected hash or it isn't hex.
+func newDigest(expected string) *digest {
+	if expected == "" || strings.Trim(expected, "0123456789abcdef") != "" {
+		return nil
+	}
+
+	algorithms, ok := hashesByLength[len(expected)]
+	if !ok {
+		algorithms = []string{"md5", "sha1", "sha256"}
+	}
+
+	d := &digest{expected: expected, known: ok, hashes: make(map[string]hash.Hash)}
+	for _, algorithm := range algorithms {
+		switch algorithm {
+		case "crc32":
+			d.hashes[algorithm] = crc32.NewIEEE()
+		case "md5":
+			d.hashes[algorithm] = md5.New()
+		case "sha1":
+			d.hashes[algorithm] = sha1.New()
+		case "sha256":
+			d.hashes[algorithm] = sha256.New()
+		}
+	}
+	return d
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// readPartial adds the first n bytes of a resumed file to the digest.
+func (d *digest) readPartial(path string, n int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.CopyN(d, file, n)
+	return err
+}
+
+func (d *digest) integrity() Integrity {
+	if d == nil {
+		return IntegrityNotCheckable
+	}
+
+	for _, h := range d.hashes {
+		if strings.HasPrefix(hex.EncodeToString(h.Sum(nil)), d.expected) {
+			return IntegrityVerified
+		}
+	}
+	if !d.known {
+		return IntegrityNotCheckable
+	}
+	return IntegrityMismatched
+}
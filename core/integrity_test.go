@@ -0,0 +1,68 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestIntegrity(t *testing.T) {
+	content := []byte("The Great Gatsby")
+
+	cases := []struct {
+		reason   string
+		expected string
+		result   Integrity
+	}{
+		{"no hash listed", "", IntegrityNotCheckable},
+		{"not a hex hash", "not-a-hash", IntegrityNotCheckable},
+		{"crc32", "c557e05d", IntegrityVerified},
+		{"md5", "cee9cf2d30c165db805e581a6f58c9aa", IntegrityVerified},
+		{"sha1", "10f77d650144962066718e700683ab2423dd6a0c", IntegrityVerified},
+		{"sha256", "708811003516929caca0647fba36ad735cbfffe4dcbdf607dbf18103e9b1bbed", IntegrityVerified},
+		{"truncated sha256", "708811003516929c", IntegrityVerified},
+		{"corrupt md5", "cee9cf2d30c165db805e581a6f58c9ab", IntegrityMismatched},
+		{"unknown algorithm", "dde55317998f25aa", IntegrityNotCheckable},
+		{"unknown length", "cee9cf2d30c165db805e581a6f58c9aa00", IntegrityNotCheckable},
+	}
+
+	for _, c := range cases {
+		d := newDigest(c.expected)
+		if d != nil {
+			d.Write(content)
+		}
+		assert.Equal(t, c.result, d.integrity(), c.reason)
+	}
+}
+
+func TestDigestReadPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "great-gatsby.txt.temp")
+	require.NoError(t, os.WriteFile(path, []byte("The Great Gatsby, or not"), 0644))
+
+	d := newDigest("708811003516929c")
+	require.NoError(t, d.readPartial(path, 10))
+	d.Write([]byte("Gatsby"))
+	assert.Equal(t, IntegrityVerified, d.integrity())
+}
+
+func TestRememberHashes(t *testing.T) {
+	conn := irc.New("evan_28", "evan_28")
+	defer endSession(conn)
+
+	RememberHashes(conn, []BookDetail{
+		{Full: "!Ook So we Read on - Maureen Corrigan.epub", Hash: "DDE55317998F25AA"},
+		{Full: "!Oatmeal So we Read on - Maureen Corrigan.epub", Hash: "cee9cf2d30c165db805e581a6f58c9aa"},
+		{Full: "!peapod The Great Gatsby.pdf"},
+	})
+
+	// Servers listing the same file don't replace each other's hashes.
+	session := sessionFor(conn)
+	assert.Equal(t, "dde55317998f25aa", session.expectedHash("Ook", "So_we_Read_on_-_Maureen_Corrigan.epub"))
+	assert.Equal(t, "cee9cf2d30c165db805e581a6f58c9aa", session.expectedHash("oatmeal", "So we Read on - Maureen Corrigan.epub"))
+	assert.Equal(t, "", session.expectedHash("peapod", "The Great Gatsby.pdf"))
+	assert.Equal(t, "", session.expectedHash("Ook", "The Great Gatsby.pdf"))
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -8,37 +9,109 @@ import (
 	"github.com/evan-buss/openbooks/irc"
 )
 
-// Specific irc.irchighway.net commands
+// Commands for book networks like irc.irchighway.net
 
-// Join connects to the irc.irchighway.net server and joins the #ebooks channel
-func Join(irc *irc.Conn, address string, enableTLS bool) error {
-	err := irc.Connect(address, enableTLS)
+// registrationTimeout limits connecting and registering when the context
+// passed to Join has no deadline.
+const registrationTimeout = 30 * time.Second
+
+// Join connects to the network and joins its channels. The channels are only
+// joined after the server has accepted the registration. If the network has a
+// nick, it replaces the connection's Username. An *irc.RegistrationError is
+// returned if the server rejects the connection.
+func Join(ctx context.Context, conn *irc.Conn, network Network) error {
+	if network.Nick != "" {
+		conn.Username = network.Nick
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, registrationTimeout)
+		defer cancel()
+	}
+
+	err := conn.ConnectContext(ctx, network.Address, network.EnableTLS)
+	if err != nil {
+		return err
+	}
+
+	err = conn.Register(ctx)
 	if err != nil {
+		conn.Close()
 		return err
 	}
-	// Wait before joining the ebooks room
-	// Often you recieve a private message from the server
-	time.Sleep(time.Second * 2)
-	irc.JoinChannel("ebooks")
+
+	for _, channel := range network.Channels {
+		err = conn.JoinChannel(ctx, channel)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// SearchBook sends a search query to the search bot
-func SearchBook(irc *irc.Conn, searchBot string, query string) {
-	searchBot = strings.TrimPrefix(searchBot, "@")
-	irc.SendMessage(fmt.Sprintf("@%s %s", searchBot, query))
+// SearchBook sends a search query to the network's search bot in the first
+// channel. The search is sent again if the connection drops before the
+// results are received.
+func SearchBook(ctx context.Context, conn *irc.Conn, network Network, query string) error {
+	_, err := searchBook(ctx, conn, network, query, OfferTimeout)
+	return err
+}
+
+// searchBook sends the search and returns the request that receives the
+// results until the timeout expired.
+func searchBook(ctx context.Context, conn *irc.Conn, network Network, query string, timeout time.Duration) (*request, error) {
+	if len(network.Channels) == 0 {
+		return nil, irc.ErrNoChannel
+	}
+
+	message := network.searchMessage(query)
+	session := sessionFor(conn)
+	r := session.expectSearch(network.SearchBot, message, searchKey(query), timeout)
+	err := conn.SendMessageTo(ctx, network.Channels[0], message)
+	if err != nil {
+		session.cancelOffer(r)
+		return nil, err
+	}
+	return r, nil
+}
+
+// DownloadBook sends the book string to the download bot. Only the server
+// named by the book string ("!Server ...") may send the file.
+func DownloadBook(ctx context.Context, irc *irc.Conn, book string) error {
+	_, err := downloadBook(ctx, irc, book, OfferTimeout)
+	return err
 }
 
-// DownloadBook sends the book string to the download bot
-func DownloadBook(irc *irc.Conn, book string) {
-	irc.SendMessage(book)
+// downloadBook requests the book and returns the request that receives the
+// offer until the timeout expired.
+func downloadBook(ctx context.Context, irc *irc.Conn, book string, timeout time.Duration) (*request, error) {
+	// Lines copied from the search results may still have the ::INFO:: and
+	// ::HASH:: fields. The hash is kept to verify the download.
+	if strings.Contains(book, "::INFO::") || strings.Contains(book, "::HASH::") {
+		if detail, err := parseLineV2(book); err == nil {
+			if detail.Hash != "" {
+				sessionFor(irc).rememberHash(detail.Full, detail.Hash)
+			}
+			book = detail.Full
+		}
+	}
+
+	session := sessionFor(irc)
+	r := session.expectOffer(downloadServer(book), false, downloadKey(book), timeout)
+	err := irc.SendMessage(ctx, book)
+	if err != nil {
+		session.cancelOffer(r)
+		return nil, err
+	}
+	return r, nil
 }
 
 // Send a CTCP Version response
-func SendVersionInfo(irc *irc.Conn, line string, version string) {
-	// Line format is like ":messager PRIVMSG #channel: message"
-	// we just want the messager without the colon
-	sender := strings.Split(line, " ")[0][1:]
+func SendVersionInfo(ctx context.Context, conn *irc.Conn, nick string, version string) error {
+	if nick == "" {
+		return nil
+	}
 	// TODO: Figure out if there's an automated way to adjust this...
-	irc.SendNotice(sender, fmt.Sprintf("\x01%s\x01", version))
+	return conn.SendNotice(ctx, nick, fmt.Sprintf("\x01%s\x01", version))
 }
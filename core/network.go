@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// Network is a profile for an IRC network that hosts book servers.
+type Network struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	EnableTLS bool   `json:"tls"`
+	// Channels are joined after registration, without the leading "#".
+	// Searches are sent to the first channel.
+	Channels []string `json:"channels"`
+	// SearchBot is the bot that answers search queries.
+	SearchBot string `json:"searchBot"`
+	// FallbackSearchBots are tried in order if the search bot doesn't answer.
+	FallbackSearchBots []string `json:"fallbackSearchBots,omitempty"`
+	// Nick overrides the configured username on this network.
+	Nick string `json:"nick,omitempty"`
+	// Trigger is the prefix used to address the search bot. Defaults to "@".
+	Trigger string `json:"trigger,omitempty"`
+}
+
+// IRCHighway is the default network.
+var IRCHighway = Network{
+	Name:      "irchighway",
+	Address:   "irc.irchighway.net:6697",
+	EnableTLS: true,
+	Channels:  []string{"ebooks"},
+	SearchBot: "search",
+	Trigger:   "@",
+}
+
+// Networks holds the available network profiles by name.
+type Networks map[string]Network
+
+// DefaultNetworks returns the built in network profiles.
+func DefaultNetworks() Networks {
+	return Networks{IRCHighway.Name: IRCHighway}
+}
+
+// LoadNetworks reads a JSON array of network profiles and adds them to the
+// built in profiles. Profiles with the same name replace the built in ones.
+func LoadNetworks(path string) (Networks, error) {
+	networks := DefaultNetworks()
+	if path == "" {
+		return networks, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []Network
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid network profiles in %s: %w", path, err)
+	}
+
+	for _, network := range profiles {
+		if err := network.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		networks[network.Name] = network
+	}
+	return networks, nil
+}
+
+// Get returns the named profile.
+func (n Networks) Get(name string) (Network, error) {
+	network, ok := n[name]
+	if !ok {
+		return Network{}, fmt.Errorf("%w %q", ErrUnknownNetwork, name)
+	}
+	return network, nil
+}
+
+// Names returns the sorted profile names.
+func (n Networks) Names() []string {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (n Network) validate() error {
+	switch {
+	case n.Name == "":
+		return errors.New("network profile is missing a name")
+	case n.Address == "":
+		return fmt.Errorf("network %q is missing an address", n.Name)
+	case len(n.Channels) == 0:
+		return fmt.Errorf("network %q has no channels", n.Name)
+	case n.SearchBot == "":
+		return fmt.Errorf("network %q is missing a search bot", n.Name)
+	}
+	return nil
+}
+
+// searchBots returns the search bot followed by the fallback bots.
+func (n Network) searchBots() []string {
+	return append([]string{n.SearchBot}, n.FallbackSearchBots...)
+}
+
+// searchMessage formats a search query for the network's search bot.
+func (n Network) searchMessage(query string) string {
+	trigger := n.Trigger
+	if trigger == "" {
+		trigger = "@"
+	}
+	bot := strings.TrimPrefix(n.SearchBot, trigger)
+	return fmt.Sprintf("%s%s %s", trigger, bot, query)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadNetworks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "networks.json")
+	profiles := `[
+		{"name": "undernet", "address": "irc.undernet.org:6667", "channels": ["bookz"], "searchBot": "search", "nick": "evan_books"},
+		{"name": "irchighway", "address": "irc.irchighway.net:6697", "tls": true, "channels": ["ebooks", "ebooks-chat"], "searchBot": "searchook"}
+	]`
+	require.NoError(t, os.WriteFile(path, []byte(profiles), 0600))
+
+	networks, err := LoadNetworks(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"irchighway", "undernet"}, networks.Names())
+
+	highway, err := networks.Get("irchighway")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ebooks", "ebooks-chat"}, highway.Channels)
+	assert.Equal(t, "searchook", highway.SearchBot)
+
+	undernet, err := networks.Get("undernet")
+	require.NoError(t, err)
+	assert.Equal(t, "evan_books", undernet.Nick)
+	assert.False(t, undernet.EnableTLS)
+
+	_, err = networks.Get("efnet")
+	assert.ErrorIs(t, err, ErrUnknownNetwork)
+}
+
+func TestLoadNetworksInvalid(t *testing.T) {
+	cases := []string{
+		`{"name": "undernet"}`,
+		`[{"address": "irc.undernet.org:6667", "channels": ["bookz"], "searchBot": "search"}]`,
+		`[{"name": "undernet", "channels": ["bookz"], "searchBot": "search"}]`,
+		`[{"name": "undernet", "address": "irc.undernet.org:6667", "searchBot": "search"}]`,
+		`[{"name": "undernet", "address": "irc.undernet.org:6667", "channels": ["bookz"]}]`,
+	}
+
+	for _, profiles := range cases {
+		path := filepath.Join(t.TempDir(), "networks.json")
+		require.NoError(t, os.WriteFile(path, []byte(profiles), 0600))
+		_, err := LoadNetworks(path)
+		assert.Error(t, err, profiles)
+	}
+}
+
+func TestSearchMessage(t *testing.T) {
+	cases := []struct {
+		network Network
+		want    string
+	}{
+		{IRCHighway, "@search the great gatsby"},
+		{Network{SearchBot: "@searchook"}, "@searchook the great gatsby"},
+		{Network{SearchBot: "search", Trigger: "!"}, "!search the great gatsby"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.network.searchMessage("the great gatsby"))
+	}
+}
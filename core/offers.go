@@ -0,0 +1,305 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/evan-buss/openbooks/util"
+)
+
+// OfferTimeout is how long a search or download request waits for the DCC
+// offer. Offers that arrive later are rejected.
+var OfferTimeout = 30 * time.Minute
+
+// maxRequests limits how many requests a session waits for. The oldest are
+// dropped first.
+const maxRequests = 100
+
+// noticeQueryRegex finds the query in notices like `Sorry, your search for
+// "zzzqqq" returned no matches.`
+var noticeQueryRegex = regexp.MustCompile(`search for "(.*?)"`)
+
+// request is a search or download that is waiting for a DCC offer.
+type request struct {
+	// sender is the lowercase nick expected to send the offer. For searches
+	// it is the search bot, which may answer from nicks like "SearchOok".
+	sender string
+	search bool
+	// key is the normalized query or file name. Notices that name another
+	// query don't complete the request. Offers prefer the request that names
+	// the offered file.
+	key     string
+	expires time.Time
+	// message is the search sent to the bot. It is sent again after a
+	// reconnect until the search is answered, cancelled or expired.
+	message string
+	// result receives the offer or the error that completed the request.
+	result chan waitResult
+	// acknowledged is closed once the search bot accepted the search.
+	acknowledged chan struct{}
+}
+
+type waitResult struct {
+	offer Offer
+	err   error
+}
+
+func (r *request) matches(nick string, search bool) bool {
+	nick = strings.ToLower(nick)
+	if search {
+		return r.search && strings.HasPrefix(nick, r.sender)
+	}
+	return !r.search && nick == r.sender
+}
+
+// answeredBy returns true if an offer or notice about key completes the
+// request. Answers that don't name their query or file complete any request
+// from the sender.
+func (r *request) answeredBy(key string) bool {
+	return key == "" || r.key == "" || r.key == key
+}
+
+// fit rates how well an offer about key fits the request. Bots often rename
+// the file they send: they drop the author, shorten long queries or change
+// the punctuation. An offer that only shares part of the key still fits
+// better than one that shares none of it.
+func (r *request) fit(key string) int {
+	switch {
+	case r.answeredBy(key):
+		return 3
+	case strings.Contains(r.key, key) || strings.Contains(key, r.key):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// complete hands the result to whoever waits for the request. A request is
+// only completed once, so the send doesn't block.
+func (r *request) complete(result waitResult) {
+	r.result <- result
+}
+
+// expectOffer registers a request that allows one DCC offer about key from
+// sender until the timeout expired.
+func (s *session) expectOffer(sender string, search bool, key string, timeout time.Duration) *request {
+	return s.expect(&request{
+		sender:  strings.ToLower(sender),
+		search:  search,
+		key:     key,
+		expires: time.Now().Add(timeout),
+	})
+}
+
+// expectSearch registers a search message sent to bot that allows one offer
+// of the results for the query with the key.
+func (s *session) expectSearch(bot string, message string, key string, timeout time.Duration) *request {
+	return s.expect(&request{
+		sender:  strings.ToLower(bot),
+		search:  true,
+		key:     key,
+		expires: time.Now().Add(timeout),
+		message: message,
+	})
+}
+
+func (s *session) expect(r *request) *request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r.result = make(chan waitResult, 1)
+	r.acknowledged = make(chan struct{})
+	s.requests = append(s.requests, r)
+	if len(s.requests) > maxRequests {
+		s.requests = s.requests[len(s.requests)-maxRequests:]
+	}
+	return r
+}
+
+// cancelOffer forgets a request that is no longer waited for.
+func (s *session) cancelOffer(r *request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, pending := range s.requests {
+		if pending == r {
+			s.requests = append(s.requests[:i], s.requests[i+1:]...)
+			return
+		}
+	}
+}
+
+// claimOffer removes and returns the request from nick that an offer about
+// key fits best. If no request names the offered file, the oldest request
+// from nick gets the offer. Expired requests are discarded. It returns nil
+// if nothing was requested.
+func (s *session) claimOffer(nick string, search bool, key string) *request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.discardExpired()
+	claimed, best := -1, 0
+	for i, r := range s.requests {
+		if !r.matches(nick, search) {
+			continue
+		}
+		if fit := r.fit(key); fit > best {
+			claimed, best = i, fit
+		}
+	}
+	return s.remove(claimed)
+}
+
+// claimNotice removes and returns the oldest request from nick that a
+// notice about key completes. Unlike offers, notices name the query the bot
+// received, so they never complete another request.
+func (s *session) claimNotice(nick string, search bool, key string) *request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.discardExpired()
+	for i, r := range s.requests {
+		if r.matches(nick, search) && r.answeredBy(key) {
+			return s.remove(i)
+		}
+	}
+	return nil
+}
+
+// claimMentioned removes and returns the oldest download from a server that
+// is named in the notice text, if any.
+func (s *session) claimMentioned(text string) *request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.discardExpired()
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`!,.:;"'()`, r)
+	})
+	for i, r := range s.requests {
+		if r.search {
+			continue
+		}
+		for _, word := range words {
+			if word == r.sender {
+				return s.remove(i)
+			}
+		}
+	}
+	return nil
+}
+
+// remove removes and returns the request at index i, or nil if i is
+// negative. The mutex must be held.
+func (s *session) remove(i int) *request {
+	if i < 0 {
+		return nil
+	}
+	r := s.requests[i]
+	s.requests = append(s.requests[:i], s.requests[i+1:]...)
+	return r
+}
+
+// acknowledgeSearch marks the searches waiting for nick as accepted by the
+// bot.
+func (s *session) acknowledgeSearch(nick string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, r := range s.requests {
+		if !r.matches(nick, true) {
+			continue
+		}
+		select {
+		case <-r.acknowledged:
+		default:
+			close(r.acknowledged)
+		}
+	}
+}
+
+// discardExpired drops the requests whose timeout expired. The mutex must be
+// held.
+func (s *session) discardExpired() {
+	now := time.Now()
+	pending := s.requests[:0]
+	for _, r := range s.requests {
+		if now.Before(r.expires) {
+			pending = append(pending, r)
+		}
+	}
+	s.requests = pending
+}
+
+// downloadServer returns the server a download command like
+// "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub" is addressed to.
+func downloadServer(book string) string {
+	server, _, _ := strings.Cut(strings.TrimSpace(book), " ")
+	return strings.TrimPrefix(server, "!")
+}
+
+// rejectedOffer describes an offer that doesn't match a pending request.
+func rejectedOffer(offer Offer) OfferRejected {
+	return OfferRejected{
+		Sender:   offer.Sender,
+		Line:     offer.Line,
+		Filename: offer.Download.Filename,
+		Reason:   fmt.Sprintf("No search or download from %s is pending.", offer.Sender),
+	}
+}
+
+// searchKey normalizes a query the way the search bot writes it into the
+// name of the results file.
+func searchKey(query string) string {
+	return normalizeKey(query)
+}
+
+// resultsKey returns the key of the query in the name of a results file like
+// "SearchBot_results_for__the_great_gatsby.txt.zip".
+func resultsKey(filename string) string {
+	_, query, ok := strings.Cut(strings.ToLower(filename), searchResultIdentifier)
+	if !ok {
+		return ""
+	}
+	query = strings.TrimSuffix(query, ".zip")
+	query = strings.TrimSuffix(query, ".txt")
+	return normalizeKey(query)
+}
+
+// noticeKey returns the key of the query mentioned in a notice, if any.
+func noticeKey(text string) string {
+	if groups := noticeQueryRegex.FindStringSubmatch(text); groups != nil {
+		return searchKey(groups[1])
+	}
+	return ""
+}
+
+// downloadKey returns the key of the file requested by a download command
+// like "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub". Lines copied
+// from the search results may still have the ::INFO:: and ::HASH:: fields.
+func downloadKey(book string) string {
+	book, _, _ = strings.Cut(book, " ::")
+	return offerKey(bookFilename(book))
+}
+
+// offerKey returns the key of an offered file. Servers may pack the listed
+// file into an archive.
+func offerKey(filename string) string {
+	if util.IsArchive(filename) {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	return normalizeKey(filename)
+}
+
+// normalizeKey keeps only the lowercase letters and digits of the text. Bots
+// replace spaces and punctuation in different ways.
+func normalizeKey(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+}
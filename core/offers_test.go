@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimOffer(t *testing.T) {
+	var s session
+	s.expectOffer("search", true, "", OfferTimeout)
+	s.expectOffer("Oatmeal", false, "", OfferTimeout)
+
+	assert.Nil(t, s.claimOffer("DV8", false, ""), "nothing was requested from DV8")
+	assert.Nil(t, s.claimOffer("Oatmeal", true, ""), "Oatmeal doesn't answer searches")
+	assert.NotNil(t, s.claimOffer("SearchOok", true, ""), "search bots answer with longer nicks")
+	assert.Nil(t, s.claimOffer("SearchOok", true, ""), "each request allows one offer")
+	assert.NotNil(t, s.claimOffer("oatmeal", false, ""))
+	assert.Nil(t, s.claimOffer("Oatmeal", false, ""))
+
+	s.expectOffer("Oatmeal", false, "", -time.Second)
+	assert.Nil(t, s.claimOffer("Oatmeal", false, ""), "the request expired")
+
+	r := s.expectOffer("Oatmeal", false, "", OfferTimeout)
+	s.cancelOffer(r)
+	assert.Nil(t, s.claimOffer("Oatmeal", false, ""), "the request was cancelled")
+}
+
+func TestClaimOfferKeys(t *testing.T) {
+	var s session
+	gatsby := s.expectOffer("search", true, searchKey("the great gatsby"), OfferTimeout)
+	hobbit := s.expectOffer("search", true, searchKey("the hobbit"), OfferTimeout)
+	book := s.expectOffer("Oatmeal", false, downloadKey("!Oatmeal The Hobbit.epub"), OfferTimeout)
+
+	// Results for the second search don't complete the first.
+	assert.Same(t, hobbit, s.claimOffer("Search", true, resultsKey("Search_results_for__the_hobbit.txt.zip")))
+	assert.Nil(t, s.claimOffer("Oatmeal", true, resultsKey("Search_results_for__dune.txt.zip")), "nothing was requested from Oatmeal")
+
+	// Answers that don't name their query complete the oldest request.
+	assert.Same(t, gatsby, s.claimOffer("SearchOok", true, ""))
+	assert.Same(t, book, s.claimOffer("Oatmeal", false, offerKey("The_Hobbit.epub.rar")))
+}
+
+func TestClaimRenamedOffer(t *testing.T) {
+	// Offers as IRCHighway's bots send them for the requests.
+	tests := []struct {
+		name     string
+		requests []string
+		offer    string
+		want     int
+	}{
+		{"same name", []string{"!Oatmeal Dune.epub", "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub"}, "F_Scott_Fitzgerald_-_The_Great_Gatsby.epub", 1},
+		{"extension case", []string{"!Oatmeal Dune.epub", "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub"}, "F Scott Fitzgerald - The Great Gatsby.EPUB", 1},
+		{"author dropped", []string{"!Oatmeal Dune.epub", "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub"}, "The_Great_Gatsby.epub", 1},
+		{"archived", []string{"!Oatmeal Dune.epub", "!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub"}, "F_Scott_Fitzgerald_-_The_Great_Gatsby.rar", 1},
+		{"renamed", []string{"!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub", "!Oatmeal Dune.epub"}, "Fitzgerald, F. Scott - Gatsby (retail).epub", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s session
+			var requests []*request
+			for _, book := range test.requests {
+				requests = append(requests, s.expectOffer(downloadServer(book), false, downloadKey(book), OfferTimeout))
+			}
+			assert.Same(t, requests[test.want], s.claimOffer("Oatmeal", false, offerKey(test.offer)))
+		})
+	}
+
+	var s session
+	s.expectOffer("search", true, searchKey("f scott fitzgerald the great gatsby"), OfferTimeout)
+	assert.NotNil(t, s.claimOffer("Search", true, resultsKey("Search_results_for__f_scott_fitzgerald_the_great.txt.zip")), "long queries are shortened")
+}
+
+func TestClaimNotice(t *testing.T) {
+	var s session
+	gatsby := s.expectOffer("search", true, searchKey("the great gatsby"), OfferTimeout)
+	ghost := s.expectOffer("Ghost", false, downloadKey("!Ghost The Great Gatsby.epub"), OfferTimeout)
+	oatmeal := s.expectOffer("Oatmeal", false, downloadKey("!Oatmeal The Great Gatsby.epub"), OfferTimeout)
+
+	assert.Nil(t, s.claimNotice("Search", true, noticeKey(`Sorry, your search for "dune" returned no matches.`)), "nobody searched for dune")
+	assert.Same(t, gatsby, s.claimNotice("Search", true, noticeKey(`Sorry, your search for "The Great Gatsby" returned no matches.`)))
+
+	assert.Nil(t, s.claimMentioned("That server is not responding, try another server."), "the notice names no server")
+	assert.Same(t, oatmeal, s.claimMentioned("!Oatmeal is not responding, try another server."))
+	assert.Same(t, ghost, s.claimNotice("Ghost", false, ""))
+}
+
+func TestPendingSearches(t *testing.T) {
+	var s session
+	gatsby := s.expectSearch("search", "@search the great gatsby", searchKey("the great gatsby"), OfferTimeout)
+	s.expectSearch("search", "@search the hobbit", searchKey("the hobbit"), OfferTimeout)
+	s.expectSearch("search", "@search dune", searchKey("dune"), -time.Second)
+	s.expectOffer("Oatmeal", false, "", OfferTimeout)
+	assert.Equal(t, []string{"@search the great gatsby", "@search the hobbit"}, s.pendingSearches())
+
+	// A skipped bot's search isn't sent again. Answers complete their own
+	// search, not the oldest one.
+	s.cancelOffer(gatsby)
+	retry := s.expectSearch("searchook", "@searchook the great gatsby", searchKey("the great gatsby"), OfferTimeout)
+	assert.Same(t, retry, s.claimOffer("SearchOok", true, resultsKey("SearchOok_results_for__the_great_gatsby.txt.zip")))
+	assert.Equal(t, []string{"@search the hobbit"}, s.pendingSearches())
+}
+
+func TestKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"search", searchKey("The Great Gatsby"), "thegreatgatsby"},
+		{"search punctuation", searchKey("  O'Brien: The Things They Carried!"), "obrienthethingstheycarried"},
+		{"results", resultsKey("SearchBot_results_for__the_great_gatsby.txt.zip"), "thegreatgatsby"},
+		{"results capitalized", resultsKey("SearchOok_Results_For_The Great-Gatsby.txt"), "thegreatgatsby"},
+		{"not results", resultsKey("great-gatsby.epub"), ""},
+		{"notice", noticeKey(`Sorry, your search for "The Great Gatsby" returned no matches.`), "thegreatgatsby"},
+		{"notice without query", noticeKey("Your search returned 1 matches."), ""},
+		{"download", downloadKey("!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub"), "fscottfitzgeraldthegreatgatsbyepub"},
+		{"download info", downloadKey("!Oatmeal great-gatsby.epub ::INFO:: 358.9KB"), "greatgatsbyepub"},
+		{"offer", offerKey("F_Scott_Fitzgerald_-_The_Great_Gatsby.epub"), "fscottfitzgeraldthegreatgatsbyepub"},
+		{"packed offer", offerKey("F Scott Fitzgerald - The Great Gatsby.epub.zip"), "fscottfitzgeraldthegreatgatsbyepub"},
+		{"packed download", downloadKey("!DV8 Douglas Adams - Hitchhiker's Guide.rar"), "douglasadamshitchhikersguide"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.got)
+		})
+	}
+}
+
+func TestDownloadServer(t *testing.T) {
+	assert.Equal(t, "Oatmeal", downloadServer("!Oatmeal F Scott Fitzgerald - The Great Gatsby.epub"))
+	assert.Equal(t, "DV8", downloadServer("  !DV8 The Great Gatsby.rar"))
+}
+
+func TestRejectUnsolicitedOffers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := make(chan net.Conn)
+	go func() {
+		client, lines := acceptAndWelcome(t, listener)
+		go func() {
+			for range lines {
+			}
+		}()
+		server <- client
+	}()
+
+	network := Network{Name: "test", Address: listener.Addr().String(), Channels: []string{"ebooks"}, SearchBot: "search"}
+	conn := irc.New("evan_bot", "OpenBooks")
+	require.NoError(t, Join(ctx, conn, network))
+	client := <-server
+	defer conn.Disconnect()
+
+	rejected := make(chan OfferRejected, 2)
+	books := make(chan BookOffer, 2)
+	events := &Events{}
+	Subscribe(events, func(e OfferRejected) { rejected <- e })
+	Subscribe(events, func(e BookOffer) { books <- e })
+	go StartReader(ctx, conn, events)
+	require.NoError(t, DownloadBook(ctx, conn, "!Oatmeal great-gatsby.epub ::INFO:: 358.9KB"))
+
+	client.Write([]byte(":DV8!andy@ihw-1.com PRIVMSG evan_bot :\x01DCC SEND evil.exe 2760158537 2050 1024\x01\r\n"))
+	client.Write([]byte(":Oatmeal!oat@ihw-2.com PRIVMSG evan_bot :\x01DCC SEND great-gatsby.epub 2760158537 2050 358887\x01\r\n"))
+
+	select {
+	case e := <-rejected:
+		assert.Equal(t, `Rejected DCC offer of "evil.exe" from DV8. No search or download from DV8 is pending.`, e.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("unsolicited offer was not rejected")
+	}
+
+	select {
+	case e := <-books:
+		assert.Equal(t, "Oatmeal", e.Sender)
+		assert.Equal(t, "great-gatsby.epub", e.Download.Filename)
+	case <-time.After(5 * time.Second):
+		t.Fatal("requested offer was not accepted")
+	}
+	assert.Empty(t, books)
+}
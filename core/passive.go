@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+)
+
+// userHostTimeout is how long a passive transfer waits for RPL_USERHOST.
+const userHostTimeout = 10 * time.Second
+
+// receivePassive listens for the sender of a passive offer, tells it where
+// to connect and writes the transfer.
+func receivePassive(ctx context.Context, conn *irc.Conn, passive dcc.Passive, sender string, download *dcc.Download, writer io.Writer) error {
+	ip, err := externalIP(ctx, conn, passive)
+	if err != nil {
+		return err
+	}
+
+	listener, err := passive.Listen()
+	if err != nil {
+		return err
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	args, err := download.PassiveReplyArgs(ip, port)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	if err := conn.SendCTCP(ctx, sender, "DCC", args); err != nil {
+		listener.Close()
+		return err
+	}
+
+	return download.Accept(ctx, listener, writer)
+}
+
+// externalIP returns the configured address or the one the IRC server sees.
+// The server is asked with USERHOST if it isn't known yet.
+func externalIP(ctx context.Context, conn *irc.Conn, passive dcc.Passive) (net.IP, error) {
+	if passive.IP != nil {
+		return passive.IP, nil
+	}
+
+	session := sessionFor(conn)
+	if ip := session.externalIP(); ip != nil {
+		return ip, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, userHostTimeout)
+	defer cancel()
+
+	reply := session.expectUserHost()
+	if err := conn.GetUserHost(ctx, conn.Nick()); err != nil {
+		return nil, err
+	}
+
+	select {
+	case ip := <-reply:
+		if ip == nil {
+			return nil, dcc.ErrNoExternalIP
+		}
+		return ip, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ctx.Err()
+		}
+		return nil, dcc.ErrNoExternalIP
+	}
+}
+
+// parseUserHost returns the address of nick from a RPL_USERHOST reply like
+// "evan_bot=+evan@203.0.113.7". It returns nil if the host is cloaked or
+// isn't an IP address.
+func parseUserHost(reply string, nick string) net.IP {
+	for _, entry := range strings.Fields(reply) {
+		name, host, found := strings.Cut(entry, "=")
+		if !found || !strings.EqualFold(strings.TrimSuffix(name, "*"), nick) {
+			continue
+		}
+
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			return net.ParseIP(host[at+1:])
+		}
+	}
+	return nil
+}
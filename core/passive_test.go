@@ -0,0 +1,26 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserHost(t *testing.T) {
+	cases := []struct {
+		reply string
+		want  net.IP
+	}{
+		{"evan_bot=+evan@203.0.113.7", net.ParseIP("203.0.113.7")},
+		{"evan_bot*=-evan@2001:db8::7", net.ParseIP("2001:db8::7")},
+		{"reader=+reader@198.51.100.1 Evan_Bot=+evan@203.0.113.7", net.ParseIP("203.0.113.7")},
+		{"evan_bot=+evan@ihw-4q5hcb.dyn.suddenlink.net", nil},
+		{"reader=+reader@198.51.100.1", nil},
+		{"", nil},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, parseUserHost(c.reply, "evan_bot"), c.reply)
+	}
+}
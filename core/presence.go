@@ -0,0 +1,236 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evan-buss/openbooks/irc"
+)
+
+// memberPrefixes are the channel membership prefixes from the highest to the
+// lowest rank.
+const memberPrefixes = "~&@%+"
+
+// modePrefixes maps the channel modes that grant a membership prefix.
+var modePrefixes = map[byte]byte{'q': '~', 'a': '&', 'o': '@', 'h': '%', 'v': '+'}
+
+// NamesRefreshInterval is how often StartReader requests the NAMES list of
+// every joined channel. This corrects membership changes that were missed.
+var NamesRefreshInterval = 10 * time.Minute
+
+// refreshNames requests NAMES for the joined channels until the context is
+// done. The replies replace the tracked lists.
+func refreshNames(ctx context.Context, conn *irc.Conn) {
+	ticker := time.NewTicker(NamesRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, channel := range conn.Channels() {
+				conn.GetUsers(ctx, channel)
+			}
+		}
+	}
+}
+
+// member is a user in a channel.
+type member struct {
+	nick string
+	// prefixes holds the membership prefixes ordered by rank.
+	prefixes string
+}
+
+// parseMember parses a name from RPL_NAMREPLY. Servers with multi-prefix
+// send every prefix the user has.
+func parseMember(name string) member {
+	nick := strings.TrimLeft(name, memberPrefixes)
+	return member{nick: nick, prefixes: name[:len(name)-len(nick)]}
+}
+
+// String returns the nick with its highest prefix, as listed by NAMES.
+func (m member) String() string {
+	if m.prefixes == "" {
+		return m.nick
+	}
+	return m.prefixes[:1] + m.nick
+}
+
+func (m *member) setPrefix(prefix byte, set bool) bool {
+	has := strings.IndexByte(m.prefixes, prefix) >= 0
+	if has == set {
+		return false
+	}
+
+	var prefixes []byte
+	for i := 0; i < len(memberPrefixes); i++ {
+		p := memberPrefixes[i]
+		if (p == prefix && set) || (p != prefix && strings.IndexByte(m.prefixes, p) >= 0) {
+			prefixes = append(prefixes, p)
+		}
+	}
+	m.prefixes = string(prefixes)
+	return true
+}
+
+// updatePresence applies membership changes to the complete names lists. It
+// returns true if a tracked channel changed. Channels that haven't received
+// RPL_ENDOFNAMES yet are ignored.
+func (r *readerState) updatePresence(msg *irc.Message) bool {
+	args := msg.Params
+	if msg.HasTrailing {
+		args = append(args[:len(args):len(args)], msg.Trailing)
+	}
+	if len(args) == 0 {
+		return false
+	}
+	nick := msg.Prefix.Nick
+
+	switch msg.Command {
+	case "JOIN":
+		return r.join(args[0], nick)
+	case "PART":
+		return r.remove(args[0], nick)
+	case "KICK":
+		if len(args) < 2 {
+			return false
+		}
+		return r.remove(args[0], args[1])
+	case "QUIT":
+		changed := false
+		for channel := range r.lists {
+			changed = r.remove(channel, nick) || changed
+		}
+		return changed
+	case "NICK":
+		changed := false
+		for channel, members := range r.lists {
+			if i := indexOfMember(members, nick); i >= 0 {
+				r.lists[channel][i].nick = args[0]
+				changed = true
+			}
+		}
+		return changed
+	case "MODE":
+		return r.mode(args[0], args[1:])
+	}
+	return false
+}
+
+func (r *readerState) join(channel, nick string) bool {
+	channel = strings.ToLower(channel)
+	members, ok := r.lists[channel]
+	if !ok || indexOfMember(members, nick) >= 0 {
+		return false
+	}
+	r.lists[channel] = append(members, member{nick: nick})
+	return true
+}
+
+func (r *readerState) remove(channel, nick string) bool {
+	channel = strings.ToLower(channel)
+	members := r.lists[channel]
+	i := indexOfMember(members, nick)
+	if i < 0 {
+		return false
+	}
+	r.lists[channel] = append(members[:i], members[i+1:]...)
+	return true
+}
+
+// mode applies prefix modes like "+vo nick1 nick2" to the channel's members.
+func (r *readerState) mode(channel string, args []string) bool {
+	members, ok := r.lists[strings.ToLower(channel)]
+	if !ok || len(args) == 0 {
+		return false
+	}
+
+	modes, params := args[0], args[1:]
+	set := true
+	changed := false
+	for i := 0; i < len(modes); i++ {
+		switch modes[i] {
+		case '+':
+			set = true
+			continue
+		case '-':
+			set = false
+			continue
+		}
+
+		prefix, ok := modePrefixes[modes[i]]
+		if !ok {
+			// Skip the parameters of list, key and limit modes.
+			if strings.IndexByte("beIk", modes[i]) >= 0 || (modes[i] == 'l' && set) {
+				if len(params) > 0 {
+					params = params[1:]
+				}
+			}
+			continue
+		}
+
+		if len(params) == 0 {
+			break
+		}
+		nick := params[0]
+		params = params[1:]
+		if j := indexOfMember(members, nick); j >= 0 {
+			changed = members[j].setPrefix(prefix, set) || changed
+		}
+	}
+	return changed
+}
+
+// allNames joins the names of every channel. Users in several channels are
+// only listed once, with their highest prefix.
+func (r *readerState) allNames() string {
+	channels := make([]string, 0, len(r.lists))
+	for channel := range r.lists {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	var names []member
+	seen := make(map[string]int)
+	for _, channel := range channels {
+		for _, m := range r.lists[channel] {
+			key := strings.ToLower(m.nick)
+			i, ok := seen[key]
+			if !ok {
+				seen[key] = len(names)
+				names = append(names, m)
+				continue
+			}
+			if rank(m) < rank(names[i]) {
+				names[i] = m
+			}
+		}
+	}
+
+	output := make([]string, len(names))
+	for i, m := range names {
+		output[i] = m.String()
+	}
+	return strings.Join(output, " ")
+}
+
+// rank returns the rank of the member's highest prefix. Lower is higher.
+func rank(m member) int {
+	if m.prefixes == "" {
+		return len(memberPrefixes)
+	}
+	return strings.IndexByte(memberPrefixes, m.prefixes[0])
+}
+
+func indexOfMember(members []member, nick string) int {
+	for i, m := range members {
+		if strings.EqualFold(m.nick, nick) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresence(t *testing.T) {
+	var state readerState
+	for _, line := range []string{
+		":irc.irchighway.net 353 evan_bot = #ebooks :evan_bot ~DV8 @+Oatmeal",
+		":irc.irchighway.net 366 evan_bot #ebooks :End of /NAMES list.",
+	} {
+		msg, err := irc.ParseMessage(line)
+		require.NoError(t, err)
+		state.classify(msg, line)
+	}
+
+	cases := []struct {
+		line  string
+		event Event
+	}{
+		{":Horla!Horla@ihw-1.com JOIN :#ebooks", servers("evan_bot ~DV8 @Oatmeal Horla")},
+		{":ChanServ!services@services.irchighway.net MODE #ebooks +v Horla", servers("evan_bot ~DV8 @Oatmeal +Horla")},
+		{":ChanServ!services@services.irchighway.net MODE #ebooks -o+b Oatmeal *!*@spam.com", servers("evan_bot ~DV8 +Oatmeal +Horla")},
+		{":ChanServ!services@services.irchighway.net MODE #ebooks +l 50", nil},
+		{":Horla!Horla@ihw-1.com NICK :Horla_away", servers("evan_bot ~DV8 +Oatmeal +Horla_away")},
+		{":Horla_away!Horla@ihw-1.com PART #ebooks :Leaving", servers("evan_bot ~DV8 +Oatmeal")},
+		{":DV8!HandyAndy@ihw-2.eu KICK #ebooks Oatmeal :Flooding", servers("evan_bot ~DV8")},
+		{":DV8!HandyAndy@ihw-2.eu QUIT :Ping timeout: 240 seconds", servers("evan_bot")},
+		{":reader!reader@ihw-3.com JOIN #bookz", nil},
+		{":reader!reader@ihw-3.com QUIT :Quit", nil},
+	}
+
+	for _, c := range cases {
+		msg, err := irc.ParseMessage(c.line)
+		require.NoError(t, err)
+
+		assert.Equal(t, c.event, state.classify(msg, c.line), c.line)
+	}
+}
+
+func servers(names string) ServerList {
+	return ServerList{ParseServers(names)}
+}
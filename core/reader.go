@@ -3,100 +3,274 @@ package core
 import (
 	"bufio"
 	"context"
-	"log"
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/evan-buss/openbooks/dcc"
 	"github.com/evan-buss/openbooks/irc"
 )
 
-type event int
+// resumeAccepted and userHostReply are handled by the reader itself. They hand
+// the position of a DCC ACCEPT and our address to the waiting downloads.
+type resumeAccepted struct{ line string }
+type userHostReply struct{ reply string }
 
-const (
-	noOp           = event(0)
-	Message        = event(1)
-	SearchResult   = event(2)
-	BookResult     = event(3)
-	NoResults      = event(4)
-	BadServer      = event(5)
-	SearchAccepted = event(6)
-	MatchesFound   = event(7)
-	ServerList     = event(8)
-	Ping           = event(9)
-	Version        = event(10)
-)
+func (resumeAccepted) event() {}
+func (userHostReply) event()  {}
 
-// Unique identifiers found in the message for various different events.
+// Unique identifiers found in the notices sent by the search and download bots.
 const (
-	pingMessage            = "PING"
-	sendMessage            = "DCC SEND"
-	noticeMessage          = "NOTICE"
 	noResults              = "Sorry"
 	serverUnavailable      = "try another server"
 	searchAccepted         = "has been accepted"
 	searchResultIdentifier = "_results_for"
-	numMatches             = "matches"
-	beginUserList          = "353"
-	endUserList            = "366"
-	versionInquiry         = "\x01VERSION\x01"
 )
 
-type HandlerFunc func(text string)
-type EventHandler map[event]HandlerFunc
+// IRC numerics used to build the list of users in the channel and to
+// find our address for passive DCC.
+const (
+	rplUserHost   = "302"
+	rplNamReply   = "353"
+	rplEndOfNames = "366"
+)
+
+var matchesRegex = regexp.MustCompile(`returned (\d+) matches`)
+
+// StartReader reads messages from the connection and publishes them as events
+// until the context is cancelled or the connection is closed with Disconnect.
+// If the connection drops, Disconnected is published and the connection is
+// restored with exponential backoff. ReaderStopped is published last and the
+// same error is returned. It is nil unless reconnecting failed for good.
+func StartReader(ctx context.Context, conn *irc.Conn, events *Events) error {
+	events.start()
+	defer endSession(conn)
+	defer events.stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go refreshNames(ctx, conn)
+
+	err := read(ctx, conn, events)
+	events.publish(ReaderStopped{Err: err})
+	return err
+}
+
+// read reads messages and reconnects until the reader should stop.
+func read(ctx context.Context, conn *irc.Conn, events *Events) error {
+	for {
+		err := readMessages(ctx, conn, events)
+		if ctx.Err() != nil || conn.Closed() {
+			return nil
+		}
+
+		reason := "Connection closed by the server."
+		if err != nil {
+			reason = err.Error()
+		}
+		events.publish(Disconnected{Reason: reason, Err: err})
+
+		if err := reconnect(ctx, conn, events); err != nil {
+			if ctx.Err() != nil || conn.Closed() {
+				return nil
+			}
+			return err
+		}
+	}
+}
 
-func StartReader(ctx context.Context, irc *irc.Conn, handler EventHandler) {
-	var users strings.Builder
-	scanner := bufio.NewScanner(irc)
+// readMessages publishes events until the connection fails or the context
+// is cancelled. It returns the read error, if any.
+func readMessages(ctx context.Context, conn *irc.Conn, events *Events) error {
+	var state readerState
+	session := sessionFor(conn)
+	scanner := bufio.NewScanner(conn)
 
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 			text := scanner.Text()
-			if err := scanner.Err(); err != nil {
-				log.Println(err)
-			}
 
 			// Send raw message if they want to recieve it (logging purposes)
-			if invoke, ok := handler[Message]; ok {
-				invoke(text)
+			events.publish(RawMessage{Line: text})
+
+			msg, err := irc.ParseMessage(text)
+			if err != nil {
+				events.publish(MalformedMessage{Line: text, Err: err})
+				continue
 			}
 
-			event := noOp
-			if strings.Contains(text, sendMessage) {
-				if strings.Contains(text, searchResultIdentifier) {
-					event = SearchResult
-				} else {
-					event = BookResult
+			switch event := state.classify(msg, text).(type) {
+			case nil:
+			case SearchResultOffer:
+				// Only accept files we asked for.
+				r := session.claimOffer(event.Sender, true, resultsKey(event.Download.Filename))
+				if r == nil {
+					events.publish(rejectedOffer(event.Offer))
+					continue
+				}
+				r.complete(waitResult{offer: event.Offer})
+				events.publish(event)
+			case BookOffer:
+				r := session.claimOffer(event.Sender, false, offerKey(event.Download.Filename))
+				if r == nil {
+					events.publish(rejectedOffer(event.Offer))
+					continue
 				}
-			} else if strings.Contains(text, noticeMessage) {
-				if strings.Contains(text, noResults) {
-					event = NoResults
-				} else if strings.Contains(text, serverUnavailable) {
-					event = BadServer
-				} else if strings.Contains(text, searchAccepted) {
-					event = SearchAccepted
-				} else if strings.Contains(text, numMatches) {
-					start := strings.LastIndex(text, "returned") + 9
-					end := strings.LastIndex(text, "matches") - 1
-					text = text[start:end]
-					event = MatchesFound
+				r.complete(waitResult{offer: event.Offer})
+				events.publish(event)
+			case NoResults:
+				r := session.claimNotice(event.From, true, noticeKey(event.Text))
+				if r == nil {
+					r = session.claimNotice(event.From, false, "")
 				}
-			} else if strings.Contains(text, beginUserList) {
-				users.WriteString(text)
-			} else if strings.Contains(text, endUserList) {
-				event = ServerList
-				text = users.String()
-				users.Reset()
-			} else if strings.Contains(text, pingMessage) {
-				event = Ping
-			} else if strings.Contains(text, versionInquiry) {
-				event = Version
+				if r != nil {
+					r.complete(waitResult{err: ErrNoResults})
+				}
+				events.publish(event)
+			case BadServer:
+				// The notice may come from another nick, like the search
+				// bot, that names the server. Notices that match no
+				// download are dropped rather than failing another one.
+				r := session.claimNotice(event.From, false, "")
+				if r == nil {
+					r = session.claimMentioned(event.Text)
+				}
+				if r != nil {
+					r.complete(waitResult{err: ErrServerUnavailable})
+				}
+				events.publish(event)
+			case SearchAccepted:
+				session.acknowledgeSearch(event.From)
+				events.publish(event)
+			case MatchesFound:
+				session.acknowledgeSearch(event.From)
+				events.publish(event)
+			case resumeAccepted:
+				if port, position, err := dcc.ParseAccept(event.line); err == nil {
+					session.acceptResume(port, position)
+				}
+			case userHostReply:
+				session.setUserHost(parseUserHost(event.reply, conn.Nick()))
+			default:
+				events.publish(event)
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readerState holds information that spans multiple IRC messages.
+type readerState struct {
+	// Names received from RPL_NAMREPLY messages per channel, waiting for
+	// RPL_ENDOFNAMES.
+	names map[string][]member
+	// Complete names lists per channel, kept up to date with membership
+	// changes.
+	lists map[string][]member
+}
+
+// classify determines which event a message represents based on its command
+// and sender. It returns nil for messages that aren't events.
+func (r *readerState) classify(msg *irc.Message, line string) Event {
+	switch msg.Command {
+	case "PING":
+		return Ping{Token: msg.Last()}
+	case rplUserHost:
+		return userHostReply{reply: msg.Trailing}
+	case rplNamReply:
+		if r.names == nil {
+			r.names = make(map[string][]member)
+		}
+		channel := strings.ToLower(msg.Param(2))
+		for _, name := range strings.Fields(msg.Trailing) {
+			r.names[channel] = append(r.names[channel], parseMember(name))
+		}
+		return nil
+	case rplEndOfNames:
+		if r.lists == nil {
+			r.lists = make(map[string][]member)
+		}
+		channel := strings.ToLower(msg.Param(1))
+		r.lists[channel] = r.names[channel]
+		delete(r.names, channel)
+		return ServerList{ParseServers(r.allNames())}
+	case "JOIN", "PART", "KICK", "QUIT", "NICK", "MODE":
+		if r.updatePresence(msg) {
+			return ServerList{ParseServers(r.allNames())}
+		}
+		return nil
+	case "PRIVMSG", "NOTICE":
+		// Offers are only valid when sent directly to us, not to a channel.
+		if isDCCSend(msg) && !isChannel(msg.Param(0)) {
+			return parseOffer(msg, line)
+		}
+
+		if command, args, ok := msg.CTCP(); ok {
+			if command == "VERSION" && msg.Command == "PRIVMSG" {
+				return VersionRequest{From: msg.Prefix.Nick}
 			}
+			if command == "DCC" && strings.HasPrefix(strings.ToUpper(args), "ACCEPT ") && !isChannel(msg.Param(0)) {
+				return resumeAccepted{line: line}
+			}
+			return nil
+		}
+
+		// Status updates are only ever sent as notices from other users.
+		// Server notices and channel chatter are ignored.
+		if msg.Command != "NOTICE" || msg.Prefix.IsServer() || msg.Prefix.User == "" {
+			return nil
+		}
+
+		from, text := msg.Prefix.Nick, msg.Last()
+		switch {
+		case strings.Contains(text, noResults):
+			return NoResults{From: from, Text: text}
+		case strings.Contains(text, serverUnavailable):
+			return BadServer{From: from, Text: text}
+		case strings.Contains(text, searchAccepted):
+			return SearchAccepted{From: from, Text: text}
+		}
 
-			if invoke, ok := handler[event]; ok {
-				go invoke(text)
+		if groups := matchesRegex.FindStringSubmatch(text); groups != nil {
+			if count, err := strconv.Atoi(groups[1]); err == nil {
+				return MatchesFound{From: from, Count: count}
 			}
 		}
+		return Notice{From: from, Text: text}
+	}
+
+	return nil
+}
+
+// parseOffer returns the offer as a SearchResultOffer or BookOffer, or
+// OfferRejected if it can't be parsed.
+func parseOffer(msg *irc.Message, line string) Event {
+	download, err := dcc.ParseString(line)
+	if err != nil {
+		return OfferRejected{Sender: msg.Prefix.Nick, Line: line, Reason: fmt.Sprintf("Unable to parse it: %v.", err)}
 	}
+
+	offer := Offer{Sender: msg.Prefix.Nick, Line: line, Download: *download}
+	if strings.Contains(msg.Last(), searchResultIdentifier) {
+		return SearchResultOffer{offer}
+	}
+	return BookOffer{offer}
+}
+
+// isDCCSend returns true if the message is a DCC SEND offer. Some clients
+// strip the CTCP delimiters so a bare "DCC SEND" prefix is accepted as well.
+func isDCCSend(msg *irc.Message) bool {
+	if command, args, ok := msg.CTCP(); ok {
+		return command == "DCC" && strings.HasPrefix(strings.ToUpper(args), "SEND ")
+	}
+	return strings.HasPrefix(msg.Last(), "DCC SEND ")
+}
+
+func isChannel(target string) bool {
+	return strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&")
 }
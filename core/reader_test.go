@@ -0,0 +1,177 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		reason string
+		line   string
+		event  Event
+	}{
+		{
+			"server ping",
+			"PING :irc.irchighway.net",
+			Ping{Token: "irc.irchighway.net"},
+		},
+		{
+			"search accepted",
+			":Search!Search@ihw-4q5hcb.dyn.suddenlink.net NOTICE evan_bot :Your search for \"the great gatsby\" has been accepted. Searching...",
+			SearchAccepted{From: "Search", Text: "Your search for \"the great gatsby\" has been accepted. Searching..."},
+		},
+		{
+			"matches found",
+			":Search!Search@ihw-4q5hcb.dyn.suddenlink.net NOTICE evan_bot :Your search for \"the great gatsby\" returned 27 matches. Sending results to you as SearchBot_results_for__the_great_gatsby.txt.zip",
+			MatchesFound{From: "Search", Count: 27},
+		},
+		{
+			"no results",
+			":Search!Search@ihw-4q5hcb.dyn.suddenlink.net NOTICE evan_bot :Sorry, your search for \"zzzqqq\" returned no matches.",
+			NoResults{From: "Search", Text: "Sorry, your search for \"zzzqqq\" returned no matches."},
+		},
+		{
+			"download server unavailable",
+			":Oatmeal!oatmeal@ihw-2hd.example.net NOTICE evan_bot :That server is not responding, try another server.",
+			BadServer{From: "Oatmeal", Text: "That server is not responding, try another server."},
+		},
+		{
+			"other notice",
+			":Oatmeal!oatmeal@ihw-2hd.example.net NOTICE evan_bot :You are number 3 in the queue.",
+			Notice{From: "Oatmeal", Text: "You are number 3 in the queue."},
+		},
+		{
+			"ctcp version inquiry",
+			":Kalashnikov!kal@ihw-8d1.example.net PRIVMSG evan_bot :\x01VERSION\x01",
+			VersionRequest{From: "Kalashnikov"},
+		},
+		{
+			"dcc resume accepted",
+			":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_bot :\x01DCC ACCEPT great-gatsby.epub 2050 1024\x01",
+			resumeAccepted{line: ":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_bot :\x01DCC ACCEPT great-gatsby.epub 2050 1024\x01"},
+		},
+		{
+			"userhost reply",
+			":irc.irchighway.net 302 evan_bot :evan_bot=+evan@203.0.113.7",
+			userHostReply{reply: "evan_bot=+evan@203.0.113.7"},
+		},
+		{
+			"malformed dcc offer",
+			":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_bot :\x01DCC SEND great-gatsby.epub 999.1.1.1 2050 1024\x01",
+			OfferRejected{
+				Sender: "DV8",
+				Line:   ":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_bot :\x01DCC SEND great-gatsby.epub 999.1.1.1 2050 1024\x01",
+				Reason: "Unable to parse it: invalid dcc address. expected an IP address or host name.",
+			},
+		},
+		{
+			"channel chatter mentioning keywords",
+			":reader!reader@ihw-0a1.example.net PRIVMSG #ebooks :PING me when the search returned 10 matches, Sorry",
+			nil,
+		},
+		{
+			"dcc offer to the whole channel",
+			":reader!reader@ihw-0a1.example.net PRIVMSG #ebooks :\x01DCC SEND virus.exe 2907707975 4342 1116\x01",
+			nil,
+		},
+		{
+			"server notice",
+			":irc.irchighway.net NOTICE evan_bot :*** Sorry, your hostname could not be found",
+			nil,
+		},
+		{
+			"numeric that isn't handled",
+			":irc.irchighway.net 372 evan_bot :- 353 matches the PING keyword",
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		var state readerState
+		msg, err := irc.ParseMessage(c.line)
+		require.NoError(t, err, c.reason)
+
+		assert.Equal(t, c.event, state.classify(msg, c.line), c.reason)
+	}
+}
+
+func TestClassifyOffers(t *testing.T) {
+	cases := []struct {
+		reason   string
+		line     string
+		search   bool
+		sender   string
+		download dcc.Download
+	}{
+		{
+			"search results offer",
+			":Search!Search@ihw-4q5hcb.dyn.suddenlink.net PRIVMSG evan_bot :\x01DCC SEND SearchBot_results_for__stephen_king_the_stand.txt.zip 2907707975 4342 1116\x01",
+			true, "Search",
+			dcc.Download{Filename: "SearchBot_results_for__stephen_king_the_stand.txt.zip", IP: "173.80.26.71", Port: "4342", Size: 1116},
+		},
+		{
+			"book offer with quoted file name",
+			":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG negative-bishop-1 :\x01DCC SEND \"Douglas Adams - [HITCHHIKER'S GUIDE TO THE GALAXY & THE 01] - Hitchhiker's Guide to the Galaxy & The (v5.0) (EPUB).rar\" 2760158537 2050 2321788\x01",
+			false, "DV8",
+			dcc.Download{Filename: "Douglas Adams - [HITCHHIKER'S GUIDE TO THE GALAXY & THE 01] - Hitchhiker's Guide to the Galaxy & The (v5.0) (EPUB).rar", IP: "164.132.173.73", Port: "2050", Size: 2321788},
+		},
+		{
+			"book offer without ctcp delimiters",
+			":SearchOok!ook@only.ook PRIVMSG evan_28 :DCC SEND great-gatsby.epub 2130706433 6669 358887",
+			false, "SearchOok",
+			dcc.Download{Filename: "great-gatsby.epub", IP: "127.0.0.1", Port: "6669", Size: 358887},
+		},
+	}
+
+	for _, c := range cases {
+		var state readerState
+		msg, err := irc.ParseMessage(c.line)
+		require.NoError(t, err, c.reason)
+
+		offer := Offer{Sender: c.sender, Line: c.line, Download: c.download}
+		if c.search {
+			assert.Equal(t, SearchResultOffer{offer}, state.classify(msg, c.line), c.reason)
+		} else {
+			assert.Equal(t, BookOffer{offer}, state.classify(msg, c.line), c.reason)
+		}
+	}
+}
+
+func TestClassifyNames(t *testing.T) {
+	lines := []string{
+		":irc.irchighway.net 353 evan_bot = #ebooks :evan_bot ~DV8 +Oatmeal",
+		":irc.irchighway.net 353 evan_bot = #ebooks :@Horla +FWServer reader",
+		":irc.irchighway.net 366 evan_bot #ebooks :End of /NAMES list.",
+	}
+
+	var state readerState
+	var event Event
+	for _, line := range lines {
+		msg, err := irc.ParseMessage(line)
+		require.NoError(t, err)
+		event = state.classify(msg, line)
+	}
+
+	assert.Equal(t, servers("evan_bot ~DV8 +Oatmeal @Horla +FWServer reader"), event)
+	assert.Equal(t, "evan_bot ~DV8 +Oatmeal @Horla +FWServer reader", state.allNames())
+	assert.Empty(t, state.names)
+
+	// Names of other channels are merged into the list.
+	lines = []string{
+		":irc.irchighway.net 353 evan_bot = #bookz :evan_bot @Horla +Bsk",
+		":irc.irchighway.net 366 evan_bot #bookz :End of /NAMES list.",
+	}
+	for _, line := range lines {
+		msg, err := irc.ParseMessage(line)
+		require.NoError(t, err)
+		event = state.classify(msg, line)
+	}
+
+	assert.Equal(t, servers("evan_bot @Horla +Bsk ~DV8 +Oatmeal +FWServer reader"), event)
+	assert.Equal(t, "evan_bot @Horla +Bsk ~DV8 +Oatmeal +FWServer reader", state.allNames())
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/evan-buss/openbooks/irc"
+)
+
+// Backoff calculates the delay before each reconnect attempt. The delay
+// doubles after every failed attempt until it reaches Max.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// ReconnectBackoff is used by StartReader when the connection drops.
+var ReconnectBackoff = Backoff{Min: 2 * time.Second, Max: 5 * time.Minute}
+
+// Delay returns the delay before the given attempt, starting at 1. Up to 20%
+// jitter is subtracted so that clients don't all reconnect at the same time.
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Min
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - jitter
+}
+
+// Rejoin replaces a dropped connection and restores the session. It registers
+// again, rejoins the channel and re-sends searches that were still waiting
+// for results.
+func Rejoin(ctx context.Context, conn *irc.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, registrationTimeout)
+	defer cancel()
+
+	err := conn.Reconnect(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, search := range sessionFor(conn).pendingSearches() {
+		err = conn.SendMessage(ctx, search)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconnect calls Rejoin with exponential backoff until it succeeds. It
+// returns an error if the reader should stop because the context was
+// cancelled or retrying can't fix the error.
+func reconnect(ctx context.Context, conn *irc.Conn, events *Events) error {
+	for attempt := 1; ; attempt++ {
+		delay := ReconnectBackoff.Delay(attempt)
+		events.publish(Reconnecting{Attempt: attempt, Delay: delay})
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		err := Rejoin(ctx, conn)
+		if err == nil {
+			events.publish(Reconnected{Nick: conn.Nick()})
+			return nil
+		}
+
+		// Disconnect was called while we were waiting. Nobody is listening.
+		if errors.Is(err, irc.ErrClosed) {
+			return err
+		}
+
+		if isPermanent(err) {
+			events.publish(Disconnected{Reason: fmt.Sprintf("Unable to reconnect. %s", err), Err: err})
+			return err
+		}
+	}
+}
+
+// isPermanent returns true for errors that won't go away by reconnecting again.
+func isPermanent(err error) bool {
+	return errors.Is(err, irc.ErrBanned) ||
+		errors.Is(err, irc.ErrAuthFailed) ||
+		errors.Is(err, irc.ErrErroneousNickname)
+}
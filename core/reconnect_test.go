@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Min: time.Second, Max: 10 * time.Second}
+
+	cases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{10, 10 * time.Second},
+	}
+
+	for _, c := range cases {
+		delay := backoff.Delay(c.attempt)
+		assert.LessOrEqual(t, delay, c.max)
+		assert.GreaterOrEqual(t, delay, c.max*4/5)
+	}
+}
+
+// acceptAndWelcome accepts a connection and completes registration. It
+// returns a channel with every line the client sends afterwards.
+func acceptAndWelcome(t *testing.T, listener net.Listener) (net.Conn, <-chan string) {
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "USER "):
+				continue
+			case strings.HasPrefix(line, "NICK "):
+				conn.Write([]byte(":mock.server 001 evan_bot :Welcome\r\n"))
+				continue
+			}
+			lines <- line
+		}
+	}()
+
+	return conn, lines
+}
+
+func TestStartReaderReconnects(t *testing.T) {
+	ReconnectBackoff = Backoff{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan (<-chan string))
+	go func() {
+		first, lines := acceptAndWelcome(t, listener)
+		for line := range lines {
+			// Drop the connection while the search is waiting for results.
+			if strings.Contains(line, "@search") {
+				first.Close()
+			}
+		}
+		_, lines = acceptAndWelcome(t, listener)
+		accepted <- lines
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	network := Network{Name: "test", Address: listener.Addr().String(), Channels: []string{"ebooks"}, SearchBot: "search"}
+	conn := irc.New("evan_bot", "OpenBooks")
+	require.NoError(t, Join(ctx, conn, network))
+
+	disconnected := make(chan Disconnected, 1)
+	reconnected := make(chan Reconnected, 1)
+	events := &Events{}
+	Subscribe(events, func(e Disconnected) { disconnected <- e })
+	Subscribe(events, func(e Reconnected) { reconnected <- e })
+	go StartReader(ctx, conn, events)
+	require.NoError(t, SearchBook(ctx, conn, network, "the great gatsby"))
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnect was not detected")
+	}
+
+	lines := <-accepted
+	assert.Equal(t, Reconnected{Nick: "evan_bot"}, <-reconnected)
+	assert.Equal(t, "JOIN #ebooks", <-lines)
+	assert.Equal(t, "PRIVMSG #ebooks :@search the great gatsby", <-lines)
+
+	conn.Disconnect()
+}
+
+func TestStartReaderStopped(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	server := make(chan net.Conn)
+	go func() {
+		client, lines := acceptAndWelcome(t, listener)
+		go func() {
+			for range lines {
+			}
+		}()
+		server <- client
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn := irc.New("evan_bot", "OpenBooks")
+	require.NoError(t, Join(ctx, conn, Network{Name: "test", Address: listener.Addr().String()}))
+	client := <-server
+	defer conn.Disconnect()
+
+	malformed := make(chan MalformedMessage, 1)
+	stopped := make(chan ReaderStopped, 1)
+	events := &Events{}
+	Subscribe(events, func(e MalformedMessage) { malformed <- e })
+	Subscribe(events, func(e ReaderStopped) { stopped <- e })
+
+	result := make(chan error, 1)
+	go func() { result <- StartReader(ctx, conn, events) }()
+
+	client.Write([]byte(":\r\n"))
+	assert.Equal(t, ":", receive(t, malformed).Line)
+
+	cancel()
+	client.Write([]byte("PING :mock.server\r\n"))
+	assert.NoError(t, <-result)
+	assert.Equal(t, ReaderStopped{}, receive(t, stopped))
+}
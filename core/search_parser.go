@@ -40,6 +40,9 @@ type BookDetail struct {
 	Format string `json:"format"`
 	Size   string `json:"size"`
 	Full   string `json:"full"`
+	// Hash is the file hash some servers list after "::HASH::". Empty if
+	// the server doesn't list one.
+	Hash string `json:"hash"`
 }
 
 type ParseError struct {
@@ -251,6 +254,20 @@ func parseLineV2(line string) (BookDetail, error) {
 		return "N/A", len(line)
 	}
 
+	getHash := func(line string) string {
+		const delimiter = "::HASH::"
+		hashIndex := strings.LastIndex(line, delimiter)
+		if hashIndex == -1 {
+			return ""
+		}
+
+		parts := strings.Fields(line[hashIndex+len(delimiter):])
+		if len(parts) == 0 {
+			return ""
+		}
+		return strings.ToLower(parts[0])
+	}
+
 	server, err := getServer(line)
 	if err != nil {
 		return BookDetail{}, err
@@ -275,5 +292,6 @@ func parseLineV2(line string) (BookDetail, error) {
 		Format: format,
 		Size:   size,
 		Full:   strings.TrimSpace(line[:endIndex]),
+		Hash:   getHash(line),
 	}, nil
 }
@@ -79,6 +79,7 @@ func TestSpecialCases(t *testing.T) {
 				Format: "epub",
 				Size:   "5MB",
 				Full:   "!Ook So we Read on -How the Great Gatsby came to be and why it Endures (2014) - Maureen Corrigan.epub",
+				Hash:   "dde55317998f25aa",
 			},
 		},
 		{
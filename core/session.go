@@ -0,0 +1,123 @@
+package core
+
+import (
+	"net"
+	"sync"
+
+	"github.com/evan-buss/openbooks/irc"
+)
+
+// session holds the state core keeps for a single IRC connection that has to
+// survive reconnects.
+type session struct {
+	mutex sync.Mutex
+	// Transfers waiting for the sender to accept a DCC RESUME, by port.
+	resumes map[string]chan int64
+	// Our address as seen by the IRC server, from RPL_USERHOST.
+	userHost net.IP
+	// Passive transfers waiting for RPL_USERHOST.
+	userHostWaiters []chan net.IP
+	// Hashes listed in search results, by normalized file name.
+	hashes map[string]string
+	// Searches and downloads waiting for a DCC offer, oldest first.
+	requests []*request
+}
+
+var sessions = struct {
+	sync.Mutex
+	active map[*irc.Conn]*session
+}{active: make(map[*irc.Conn]*session)}
+
+// sessionFor returns the session for the connection, creating it if needed.
+func sessionFor(conn *irc.Conn) *session {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	s, ok := sessions.active[conn]
+	if !ok {
+		s = &session{}
+		sessions.active[conn] = s
+	}
+	return s
+}
+
+// endSession discards the session once the connection is no longer used.
+func endSession(conn *irc.Conn) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	delete(sessions.active, conn)
+}
+
+// pendingSearches returns the messages of the searches that are still
+// waiting for results, oldest first.
+func (s *session) pendingSearches() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.discardExpired()
+	var messages []string
+	for _, r := range s.requests {
+		if r.search {
+			messages = append(messages, r.message)
+		}
+	}
+	return messages
+}
+
+// expectResume registers a transfer that waits for DCC ACCEPT. The position
+// accepted by the sender is sent on the returned channel.
+func (s *session) expectResume(port string) <-chan int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.resumes == nil {
+		s.resumes = make(map[string]chan int64)
+	}
+	accepted := make(chan int64, 1)
+	s.resumes[port] = accepted
+	return accepted
+}
+
+// acceptResume hands the accepted position to the waiting transfer.
+func (s *session) acceptResume(port string, position int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if accepted, ok := s.resumes[port]; ok {
+		accepted <- position
+		delete(s.resumes, port)
+	}
+}
+
+func (s *session) cancelResume(port string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.resumes, port)
+}
+
+// expectUserHost registers a transfer that waits for RPL_USERHOST.
+func (s *session) expectUserHost() <-chan net.IP {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	waiter := make(chan net.IP, 1)
+	s.userHostWaiters = append(s.userHostWaiters, waiter)
+	return waiter
+}
+
+// setUserHost records our address and hands it to the waiting transfers. The
+// address is nil if the server hides our host.
+func (s *session) setUserHost(ip net.IP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ip != nil {
+		s.userHost = ip
+	}
+	for _, waiter := range s.userHostWaiters {
+		waiter <- ip
+	}
+	s.userHostWaiters = nil
+}
+
+func (s *session) externalIP() net.IP {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.userHost
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/util"
+)
+
+var (
+	ErrTooLarge      = errors.New("file is larger than the maximum size")
+	ErrNoSpace       = errors.New("not enough free disk space")
+	ErrQuotaExceeded = errors.New("download directory quota exceeded")
+)
+
+// Declined returns true if the error is one of the reasons an offer is
+// declined before any data is transferred.
+func Declined(err error) bool {
+	return errors.Is(err, ErrTooLarge) || errors.Is(err, ErrNoSpace) ||
+		errors.Is(err, ErrQuotaExceeded) || errors.Is(err, dcc.ErrPrivateAddress)
+}
+
+// checkStorage returns an error if the offered file doesn't fit. Only the
+// bytes missing from a partial file at path count. Archives need room for
+// the extracted file as well.
+func checkStorage(config dcc.Config, dir, path string, download *dcc.Download) error {
+	if config.MaxSize > 0 && download.Size > config.MaxSize {
+		return fmt.Errorf("%w: %q is %s, the limit is %s", ErrTooLarge,
+			download.Filename, util.FormatSize(download.Size), util.FormatSize(config.MaxSize))
+	}
+
+	needed := download.Size
+	if info, err := os.Stat(path); err == nil && info.Size() < download.Size {
+		needed -= info.Size()
+	}
+	if util.IsArchive(path) {
+		needed += download.Size
+	}
+
+	// Platforms that can't tell the free space skip the check.
+	if free, err := util.FreeSpace(dir); err == nil && needed > free {
+		return fmt.Errorf("%w: %q needs %s, %s is available", ErrNoSpace,
+			download.Filename, util.FormatSize(needed), util.FormatSize(free))
+	}
+
+	if config.Quota > 0 {
+		used, err := util.DirSize(dir)
+		if err != nil {
+			return err
+		}
+		if used+needed > config.Quota {
+			return fmt.Errorf("%w: %q needs %s, %s of %s is left", ErrQuotaExceeded,
+				download.Filename, util.FormatSize(needed), util.FormatSize(max64(config.Quota-used, 0)), util.FormatSize(config.Quota))
+		}
+	}
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
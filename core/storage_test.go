@@ -0,0 +1,45 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStorage(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "the-stand.epub"), make([]byte, 600), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "it.epub.temp"), make([]byte, 200), 0644))
+
+	cases := []struct {
+		reason   string
+		config   dcc.Config
+		filename string
+		size     int64
+		err      error
+	}{
+		{"no limits", dcc.Config{}, "great-gatsby.epub", 1000, nil},
+		{"too large", dcc.Config{MaxSize: 999}, "great-gatsby.epub", 1000, ErrTooLarge},
+		{"at the maximum size", dcc.Config{MaxSize: 1000}, "great-gatsby.epub", 1000, nil},
+		{"within the quota", dcc.Config{Quota: 1200}, "great-gatsby.epub", 400, nil},
+		{"over the quota", dcc.Config{Quota: 1200}, "great-gatsby.epub", 401, ErrQuotaExceeded},
+		{"only the missing bytes of a partial file count", dcc.Config{Quota: 1200}, "it.epub", 600, nil},
+		{"archives are extracted next to themselves", dcc.Config{Quota: 1200}, "great-gatsby.zip", 300, ErrQuotaExceeded},
+		{"more than the disk holds", dcc.Config{}, "great-gatsby.epub", 1 << 62, ErrNoSpace},
+	}
+
+	for _, c := range cases {
+		download := &dcc.Download{Filename: c.filename, Size: c.size}
+		err := checkStorage(c.config, dir, filepath.Join(dir, c.filename+".temp"), download)
+		if c.err == nil {
+			assert.NoError(t, err, c.reason)
+		} else {
+			assert.ErrorIs(t, err, c.err, c.reason)
+			assert.True(t, Declined(err), c.reason)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package dcc
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evan-buss/openbooks/proxy"
 )
 
 // There are two types of DCC strings this program accepts.
@@ -14,28 +20,69 @@ import (
 
 var (
 	ErrInvalidDCCString = errors.New("invalid dcc send string")
-	ErrInvalidIP        = errors.New("unable to convert int IP to string")
+	ErrInvalidIP        = errors.New("invalid dcc address. expected an IP address or host name")
 	ErrMissingBytes     = errors.New("download size didn't match dcc file size. data could be missing")
+	ErrPrivateAddress   = errors.New("dcc offer points to a private address")
 )
 
-var dccRegex = regexp.MustCompile(`DCC SEND "?(.+[^"])"?\s(\d+)\s+(\d+)\s+(\d+)\s*`)
+// The address is usually a 32 bit integer, but some senders use dotted IPv4,
+// IPv6 literals or host names. See parseAddress.
+var dccRegex = regexp.MustCompile(`DCC SEND "?(.+[^"])"?\s(\S+)\s+(\d+)\s+(\d+)\s*`)
+
+// Passive offers use port 0 and end with a token. They are matched first
+// because dccRegex would treat the address as part of the file name.
+var passiveRegex = regexp.MustCompile(`DCC SEND "?(.+?)"?\s(\S+)\s+0\s+(\d+)\s+(\d+)\s*\x01?\s*$`)
+
+var hostnameRegex = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*\.?$`)
+var acceptRegex = regexp.MustCompile(`DCC ACCEPT "?(.+[^"])"?\s(\d+)\s+(\d+)\s*`)
 
 type Download struct {
 	Filename string
 	IP       string
 	Port     string
 	Size     int64
+	// Dialer connects to the sender. Defaults to proxy.Direct.
+	Dialer proxy.Dialer
+	// Offset is the number of bytes received by an earlier transfer. It is
+	// set once the sender accepted a DCC RESUME request for that position.
+	Offset int64
+	// Token identifies a passive offer. Empty for regular offers.
+	Token string
+	// Timeouts limit the transfer. Zero durations disable the limits.
+	Timeouts Timeouts
+	// Throttle limits the transfer rate. Optional.
+	Throttle *Throttle
 }
 
 // ParseString parses the important data of a DCC SEND string
 func ParseString(text string) (*Download, error) {
+	if groups := passiveRegex.FindStringSubmatch(text); groups != nil {
+		ip, err := parseAddress(groups[2])
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(groups[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Download{
+			Filename: groups[1],
+			IP:       ip,
+			Port:     "0",
+			Size:     size,
+			Token:    groups[4],
+		}, nil
+	}
+
 	groups := dccRegex.FindStringSubmatch(text)
 
 	if len(groups) == 0 {
 		return nil, ErrInvalidDCCString
 	}
 
-	ip, err := stringToIP(groups[2])
+	ip, err := parseAddress(groups[2])
 	if err != nil {
 		return nil, err
 	}
@@ -53,15 +100,62 @@ func ParseString(text string) (*Download, error) {
 	}, nil
 }
 
-// Download writes the data contained in the DCC Download
-func (download Download) Download(writer io.Writer) error {
-	// TODO: Maybe specify deadline?
-	conn, err := net.Dial("tcp", download.IP+":"+download.Port)
+// ParseAccept parses the port and position of a DCC ACCEPT reply to a
+// DCC RESUME request.
+func ParseAccept(text string) (port string, position int64, err error) {
+	groups := acceptRegex.FindStringSubmatch(text)
+	if len(groups) == 0 {
+		return "", 0, ErrInvalidDCCString
+	}
+
+	position, err = strconv.ParseInt(groups[3], 10, 64)
+	if err != nil {
+		return "", 0, err
+	}
+	return groups[2], position, nil
+}
+
+// ResumeArgs returns the CTCP DCC arguments that ask the sender to continue
+// the transfer from position.
+func (download Download) ResumeArgs(position int64) string {
+	return fmt.Sprintf("RESUME %s %s %d", quoteFilename(download.Filename), download.Port, position)
+}
+
+func quoteFilename(filename string) string {
+	if strings.Contains(filename, " ") {
+		return `"` + filename + `"`
+	}
+	return filename
+}
+
+// Download writes the data contained in the DCC Download. When resuming,
+// only the bytes after Offset are written. The connection is closed if the
+// context is cancelled or one of the Timeouts expires. The context's error or
+// ErrStalled is returned in that case.
+func (download Download) Download(ctx context.Context, writer io.Writer) error {
+	ctx, cancel := withTimeout(ctx, download.Timeouts.Overall)
+	defer cancel()
+
+	dialCtx, cancelDial := withTimeout(ctx, download.Timeouts.Connect)
+	// JoinHostPort adds the brackets IPv6 addresses need.
+	conn, err := proxy.Dial(dialCtx, download.Dialer, net.JoinHostPort(download.IP, download.Port))
+	cancelDial()
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
 	defer conn.Close()
 
+	return download.receive(ctx, conn, writer)
+}
+
+// receive copies the transfer from the sender's connection to writer.
+func (download Download) receive(ctx context.Context, conn net.Conn, writer io.Writer) error {
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
 	// NOTE: Not using the idiomatic io.Copy or io.CopyBuffer because they are
 	// much slower in real world tests than the manual way. I suspect it has to
 	// do with the way the DCC server is sending data. I don't think it ever sends
@@ -72,28 +166,91 @@ func (download Download) Download(writer io.Writer) error {
 	// Copy - 2m35s
 	// Custom - 1024 - 35s
 	// Custom - 4096 - 46s, 14s
-	received := 0
+	var tr *transfer
+	if download.Throttle != nil {
+		tr = download.Throttle.start(download.Filename)
+		defer download.Throttle.done(tr)
+	}
+
+	received := download.Offset
 	bytes := make([]byte, 4096)
-	for int64(received) < download.Size {
+	for received < download.Size {
+		if download.Timeouts.Idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(download.Timeouts.Idle))
+		}
+
 		n, err := conn.Read(bytes)
 		if err != nil {
-			return err
+			return readError(ctx, err)
 		}
 
 		_, err = writer.Write(bytes[:n])
 		if err != nil {
 			return err
 		}
-		received += n
+		received += int64(n)
+
+		if tr != nil {
+			if err := download.Throttle.wait(ctx, tr, n); err != nil {
+				return err
+			}
+		}
 	}
 
-	if int64(received) != download.Size {
+	if received != download.Size {
 		return ErrMissingBytes
 	}
 
 	return nil
 }
 
+// Hostname returns true if the sender's address is a host name instead of
+// an IP address.
+func (download Download) Hostname() bool {
+	return net.ParseIP(download.IP) == nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598).
+var sharedAddressSpace = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PrivateAddress returns true if the sender's address isn't a public one.
+// Connecting to it would reach a host on our own network instead. Host names
+// have to be resolved first; they always return false.
+func (download Download) PrivateAddress() bool {
+	ip := net.ParseIP(download.IP)
+	return ip != nil && PrivateIP(ip)
+}
+
+// PrivateIP returns true for private, loopback, link-local, unspecified and
+// carrier-grade NAT addresses.
+func PrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// parseAddress returns the address of a DCC offer as a dotted IPv4 address,
+// an IPv6 address or a host name.
+// Ex) 2760158537 -> 164.132.173.73, 164.132.173.73, 2001:db8::1, dcc.example.org
+func parseAddress(address string) (string, error) {
+	if strings.Trim(address, "0123456789") == "" {
+		return stringToIP(address)
+	}
+
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String(), nil
+	}
+
+	// Top level domains aren't numeric, which rules out broken IPv4
+	// addresses like "164.132.173".
+	host := strings.TrimSuffix(address, ".")
+	tld := host[strings.LastIndex(host, ".")+1:]
+	if len(host) > 253 || !hostnameRegex.MatchString(address) || strings.Trim(tld, "0123456789") == "" {
+		return "", ErrInvalidIP
+	}
+	return host, nil
+}
+
 // Convert a given 32 bit IP integer to an IP string
 // Ex) 2907707975 -> 192.168.1.1
 func stringToIP(nn string) (string, error) {
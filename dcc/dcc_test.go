@@ -2,9 +2,14 @@ package dcc
 
 import (
 	"bytes"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/evan-buss/openbooks/mock"
 )
@@ -28,6 +33,14 @@ func TestStringParsing(t *testing.T) {
 			`:DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG negative-bishop-1 :DCC SEND "Douglas Adams - [HITCHHIKER'S GUIDE TO THE GALAXY & THE 01] - Hitchhiker's Guide to the Galaxy & The (v5.0) (EPUB).rar" 2760158537 2050 2321788`,
 			&Download{Filename: "Douglas Adams - [HITCHHIKER'S GUIDE TO THE GALAXY & THE 01] - Hitchhiker's Guide to the Galaxy & The (v5.0) (EPUB).rar", IP: "164.132.173.73", Port: "2050", Size: 2321788},
 		},
+		{
+			":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_28 :\x01DCC SEND great-gatsby.epub 2760158537 0 358887 42\x01",
+			&Download{Filename: "great-gatsby.epub", IP: "164.132.173.73", Port: "0", Size: 358887, Token: "42"},
+		},
+		{
+			`:DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_28 :DCC SEND "F. Scott Fitzgerald - The Great Gatsby 1925.epub" 2760158537 0 358887 7`,
+			&Download{Filename: "F. Scott Fitzgerald - The Great Gatsby 1925.epub", IP: "164.132.173.73", Port: "0", Size: 358887, Token: "7"},
+		},
 	}
 
 	for _, table := range tables {
@@ -37,6 +50,46 @@ func TestStringParsing(t *testing.T) {
 	}
 }
 
+// TestParseAddresses covers the address formats used by senders: integer
+// and dotted IPv4, IPv6 literals and host names.
+func TestParseAddresses(t *testing.T) {
+	tables := []struct {
+		reason   string
+		args     string
+		filename string
+		ip       string
+		port     string
+		err      error
+	}{
+		{"integer IPv4", "great-gatsby.epub 2760158537 2050 358887", "great-gatsby.epub", "164.132.173.73", "2050", nil},
+		{"dotted IPv4", "great-gatsby.epub 164.132.173.73 2050 358887", "great-gatsby.epub", "164.132.173.73", "2050", nil},
+		{"IPv6", "great-gatsby.epub 2001:db8::c0a8:101 2050 358887", "great-gatsby.epub", "2001:db8::c0a8:101", "2050", nil},
+		{"expanded IPv6", "great-gatsby.epub 2001:0db8:0000:0000:0000:0000:c0a8:0101 2050 358887", "great-gatsby.epub", "2001:db8::c0a8:101", "2050", nil},
+		{"IPv4 mapped IPv6", "great-gatsby.epub ::ffff:164.132.173.73 2050 358887", "great-gatsby.epub", "164.132.173.73", "2050", nil},
+		{"host name", "great-gatsby.epub dcc.example.org 2050 358887", "great-gatsby.epub", "dcc.example.org", "2050", nil},
+		{"fully qualified host name", "great-gatsby.epub dcc-1.Example.org. 2050 358887", "great-gatsby.epub", "dcc-1.Example.org", "2050", nil},
+		{"quoted file name and IPv6", "\"The Great Gatsby.epub\" 2001:db8::1 2050 358887", "The Great Gatsby.epub", "2001:db8::1", "2050", nil},
+		{"passive IPv6", "great-gatsby.epub 2001:db8::1 0 358887 42", "great-gatsby.epub", "2001:db8::1", "0", nil},
+		{"passive host name", "great-gatsby.epub dcc.example.org 0 358887 42", "great-gatsby.epub", "dcc.example.org", "0", nil},
+		{"integer too large", "great-gatsby.epub 4294967296 2050 358887", "", "", "", ErrInvalidIP},
+		{"incomplete IPv4", "great-gatsby.epub 164.132.173 2050 358887", "", "", "", ErrInvalidIP},
+		{"invalid host name", "great-gatsby.epub dcc_example!org 2050 358887", "", "", "", ErrInvalidIP},
+	}
+
+	for _, table := range tables {
+		download, err := ParseString(":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_28 :\x01DCC SEND " + table.args + "\x01")
+		if table.err != nil {
+			assert.ErrorIs(t, err, table.err, table.reason)
+			continue
+		}
+		require.NoError(t, err, table.reason)
+		assert.Equal(t, table.filename, download.Filename, table.reason)
+		assert.Equal(t, table.ip, download.IP, table.reason)
+		assert.Equal(t, table.port, download.Port, table.reason)
+		assert.Equal(t, int64(358887), download.Size, table.reason)
+	}
+}
+
 func TestDownload(t *testing.T) {
 	text := "Test dcc download content."
 
@@ -60,7 +113,315 @@ func TestDownload(t *testing.T) {
 	t.Log("After server start")
 
 	received := new(mock.WriteCloser)
-	err := textDownload.Download(received)
+	err := textDownload.Download(context.Background(), received)
 	require.NoError(t, err)
 	assert.Equal(t, text, string(received.Data))
 }
+
+func TestParseAccept(t *testing.T) {
+	tables := []struct {
+		accept   string
+		port     string
+		position int64
+	}{
+		{":SearchOok!ook@only.ook PRIVMSG evan_28 :\x01DCC ACCEPT great-gatsby.epub 6669 1024\x01", "6669", 1024},
+		{":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_28 :\x01DCC ACCEPT \"Douglas Adams - Hitchhiker's Guide (EPUB).rar\" 2050 2048\x01", "2050", 2048},
+		{":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG evan_28 :\x01DCC ACCEPT file.ext 2050 0\x01", "2050", 0},
+	}
+
+	for _, table := range tables {
+		port, position, err := ParseAccept(table.accept)
+		require.NoError(t, err)
+		assert.Equal(t, table.port, port)
+		assert.Equal(t, table.position, position)
+	}
+
+	_, _, err := ParseAccept("DCC ACCEPT great-gatsby.epub")
+	assert.ErrorIs(t, err, ErrInvalidDCCString)
+}
+
+func TestResumeArgs(t *testing.T) {
+	download := Download{Filename: "great-gatsby.epub", Port: "6669"}
+	assert.Equal(t, "RESUME great-gatsby.epub 6669 1024", download.ResumeArgs(1024))
+
+	download.Filename = "The Great Gatsby.epub"
+	assert.Equal(t, `RESUME "The Great Gatsby.epub" 6669 1024`, download.ResumeArgs(1024))
+}
+
+func TestPrivateAddress(t *testing.T) {
+	cases := []struct {
+		ip      string
+		private bool
+	}{
+		{"164.132.173.73", false},
+		{"10.0.0.8", true},
+		{"172.16.4.2", true},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"0.0.0.0", true},
+		{"169.254.10.1", true},
+		{"100.64.0.1", true},
+		{"2001:db8::1", false},
+		{"fd00::1", true},
+		{"::1", true},
+		{"fe80::1", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.private, Download{IP: c.ip}.PrivateAddress(), c.ip)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	text := "Test dcc download content that is resumed."
+	offset := int64(10)
+
+	textDownload := Download{
+		Filename: "test.txt",
+		IP:       "localhost",
+		Port:     "6970",
+		Size:     int64(len(text)),
+		Offset:   offset,
+	}
+
+	server := mock.DccServer{
+		Port:   ":" + textDownload.Port,
+		Reader: bytes.NewReader([]byte(text)),
+	}
+
+	ready := make(chan struct{}, 1)
+	go server.Start(ready)
+	<-ready
+	server.Resume(offset)
+
+	received := new(mock.WriteCloser)
+	err := textDownload.Download(context.Background(), received)
+	require.NoError(t, err)
+	assert.Equal(t, text[offset:], string(received.Data))
+}
+
+func TestParsePortRange(t *testing.T) {
+	tables := []struct {
+		text string
+		min  int
+		max  int
+		err  bool
+	}{
+		{"", 0, 0, false},
+		{"6000", 6000, 6000, false},
+		{"6000-6010", 6000, 6010, false},
+		{"6010-6000", 0, 0, true},
+		{"0-10", 0, 0, true},
+		{"6000-70000", 0, 0, true},
+		{"dcc", 0, 0, true},
+	}
+
+	for _, table := range tables {
+		min, max, err := ParsePortRange(table.text)
+		if table.err {
+			assert.ErrorIs(t, err, ErrInvalidPortRange, table.text)
+			continue
+		}
+		require.NoError(t, err, table.text)
+		assert.Equal(t, table.min, min, table.text)
+		assert.Equal(t, table.max, max, table.text)
+	}
+}
+
+func TestPassiveReplyArgs(t *testing.T) {
+	download := Download{Filename: "The Great Gatsby.epub", Port: "0", Size: 358887, Token: "42"}
+
+	args, err := download.PassiveReplyArgs(net.ParseIP("173.80.26.71"), 6001)
+	require.NoError(t, err)
+	assert.Equal(t, `SEND "The Great Gatsby.epub" 2907707975 6001 358887 42`, args)
+
+	args, err = download.PassiveReplyArgs(net.ParseIP("2001:db8::1"), 6001)
+	require.NoError(t, err)
+	assert.Equal(t, `SEND "The Great Gatsby.epub" 2001:db8::1 6001 358887 42`, args)
+
+	_, err = download.PassiveReplyArgs(nil, 6001)
+	assert.ErrorIs(t, err, ErrNoExternalIP)
+}
+
+func TestAcceptPassive(t *testing.T) {
+	text := "Test passive dcc download content."
+
+	passive := Passive{PortMin: 6972, PortMax: 6974}
+	listener, err := passive.Listen()
+	require.NoError(t, err)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	assert.GreaterOrEqual(t, port, passive.PortMin)
+	assert.LessOrEqual(t, port, passive.PortMax)
+
+	sender := mock.DccServer{Reader: bytes.NewReader([]byte(text))}
+	go sender.Send(net.JoinHostPort("localhost", strconv.Itoa(port)))
+
+	download := Download{Filename: "test.txt", Port: "0", Size: int64(len(text)), Token: "1"}
+	received := new(mock.WriteCloser)
+	require.NoError(t, download.Accept(context.Background(), listener, received))
+	assert.Equal(t, text, string(received.Data))
+}
+
+// stalledSender accepts a connection and sends part of the data before it
+// stops sending without closing the connection.
+func stalledSender(t *testing.T, data string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(data))
+		time.Sleep(5 * time.Second)
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestDownloadTimeouts(t *testing.T) {
+	text := "Test dcc download content."
+
+	cases := []struct {
+		reason   string
+		timeouts Timeouts
+		cancel   time.Duration
+		err      error
+	}{
+		{"idle timeout", Timeouts{Idle: 50 * time.Millisecond}, 0, ErrStalled},
+		{"overall deadline", Timeouts{Overall: 50 * time.Millisecond}, 0, context.DeadlineExceeded},
+		{"cancelled", Timeouts{}, 50 * time.Millisecond, context.Canceled},
+	}
+
+	for _, c := range cases {
+		host, port, err := net.SplitHostPort(stalledSender(t, text[:10]))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.cancel > 0 {
+			time.AfterFunc(c.cancel, cancel)
+		}
+
+		download := Download{Filename: "test.txt", IP: host, Port: port, Size: int64(len(text)), Timeouts: c.timeouts}
+		received := new(mock.WriteCloser)
+		start := time.Now()
+		err = download.Download(ctx, received)
+		cancel()
+
+		assert.ErrorIs(t, err, c.err, c.reason)
+		assert.Less(t, time.Since(start), time.Second, c.reason)
+		assert.Equal(t, text[:10], string(received.Data), c.reason)
+	}
+}
+
+func TestAcceptNoConnection(t *testing.T) {
+	listener, err := Passive{}.Listen()
+	require.NoError(t, err)
+
+	download := Download{Filename: "test.txt", Port: "0", Size: 10, Token: "1", Timeouts: Timeouts{Connect: 50 * time.Millisecond}}
+	err = download.Accept(context.Background(), listener, new(mock.WriteCloser))
+	assert.ErrorIs(t, err, ErrNoConnection)
+}
+
+func TestProgressWriter(t *testing.T) {
+	var reports []Progress
+	writer := NewProgressWriter("test.txt", 100, time.Hour, func(progress Progress) {
+		reports = append(reports, progress)
+	})
+	writer.Resume(40)
+
+	// Reports are throttled to the interval until the transfer completes.
+	for i := 0; i < 5; i++ {
+		n, err := writer.Write(make([]byte, 10))
+		require.NoError(t, err)
+		assert.Equal(t, 10, n)
+	}
+	assert.Empty(t, reports)
+
+	writer.Write(make([]byte, 10))
+	require.Len(t, reports, 1)
+	assert.Equal(t, "test.txt", reports[0].Filename)
+	assert.Equal(t, int64(100), reports[0].Received)
+	assert.Equal(t, int64(100), reports[0].Size)
+	assert.Greater(t, reports[0].Rate, 0.0)
+	assert.Zero(t, reports[0].ETA)
+
+	reports = nil
+	writer = NewProgressWriter("test.txt", 100, 0, func(progress Progress) {
+		reports = append(reports, progress)
+	})
+	time.Sleep(10 * time.Millisecond)
+	writer.Write(make([]byte, 50))
+	require.Len(t, reports, 1)
+	assert.Equal(t, int64(50), reports[0].Received)
+	assert.Greater(t, reports[0].ETA, time.Duration(0))
+}
+
+func TestThrottle(t *testing.T) {
+	text := strings.Repeat("The Great Gatsby", 1024)
+	host, port, err := net.SplitHostPort(stalledSender(t, text))
+	require.NoError(t, err)
+
+	// The first 8KB fit the burst, the rest arrives at 8KB per second.
+	throttle := NewThrottle(0, 8192)
+	download := Download{Filename: "test.txt", IP: host, Port: port, Size: int64(len(text)), Throttle: throttle}
+	received := new(mock.WriteCloser)
+	start := time.Now()
+	require.NoError(t, download.Download(context.Background(), received))
+
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	assert.Equal(t, text, string(received.Data))
+
+	stats := throttle.Stats()
+	assert.Equal(t, int64(8192), stats.TransferLimit)
+	assert.Empty(t, stats.Active)
+	assert.Equal(t, 1, stats.Finished)
+	assert.Equal(t, int64(len(text)), stats.Received)
+
+	throttle.SetLimits(1<<20, 0)
+	stats = throttle.Stats()
+	assert.Equal(t, int64(1<<20), stats.Limit)
+	assert.Equal(t, int64(0), stats.TransferLimit)
+}
+
+func TestDownloadAddresses(t *testing.T) {
+	text := "Test dcc download content."
+
+	cases := []struct {
+		listen string
+		ip     string
+	}{
+		{"127.0.0.1:0", "127.0.0.1"},
+		{"[::1]:0", "::1"},
+		{"127.0.0.1:0", "localhost"},
+	}
+
+	for _, c := range cases {
+		listener, err := net.Listen("tcp", c.listen)
+		if err != nil {
+			t.Logf("skipping %s: %v", c.ip, err)
+			continue
+		}
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte(text))
+		}()
+
+		port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		download := Download{Filename: "test.txt", IP: c.ip, Port: port, Size: int64(len(text))}
+		received := new(mock.WriteCloser)
+		err = download.Download(context.Background(), received)
+		listener.Close()
+
+		require.NoError(t, err, c.ip)
+		assert.Equal(t, text, string(received.Data), c.ip)
+	}
+}
@@ -0,0 +1,122 @@
+package dcc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPortRange = errors.New("invalid port range")
+	ErrNoExternalIP     = errors.New("external IP address is unknown. set it to accept passive dcc transfers")
+)
+
+// Passive configures how passive (reverse) DCC offers are accepted. The
+// sender connects to us instead of the other way around, which lets senders
+// behind NAT transfer files.
+type Passive struct {
+	// PortMin and PortMax are the inclusive range of ports to listen on.
+	// Any free port is used if both are zero.
+	PortMin int
+	PortMax int
+	// IP is the external address sent to the sender. It is detected from
+	// the IRC server if empty.
+	IP net.IP
+}
+
+// ParsePortRange parses a single port ("6000") or an inclusive range of
+// ports ("6000-6010").
+func ParsePortRange(text string) (min, max int, err error) {
+	if text == "" {
+		return 0, 0, nil
+	}
+
+	first, last, found := strings.Cut(text, "-")
+	if !found {
+		last = first
+	}
+
+	min, err = strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w %q", ErrInvalidPortRange, text)
+	}
+	max, err = strconv.Atoi(strings.TrimSpace(last))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w %q", ErrInvalidPortRange, text)
+	}
+
+	if min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("%w %q", ErrInvalidPortRange, text)
+	}
+	return min, max, nil
+}
+
+// Listen listens on the first free port in the range.
+func (p Passive) Listen() (net.Listener, error) {
+	if p.PortMin == 0 && p.PortMax == 0 {
+		return net.Listen("tcp", ":0")
+	}
+
+	var err error
+	for port := p.PortMin; port <= p.PortMax; port++ {
+		var listener net.Listener
+		listener, err = net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("no free port between %d and %d: %w", p.PortMin, p.PortMax, err)
+}
+
+// Passive returns true if the sender waits for us to connect to it. These
+// offers have port 0 and a token that identifies the transfer.
+func (download Download) Passive() bool {
+	return download.Token != ""
+}
+
+// PassiveReplyArgs returns the CTCP DCC arguments that tell the sender of a
+// passive offer where to connect.
+func (download Download) PassiveReplyArgs(ip net.IP, port int) (string, error) {
+	address := ip.String()
+	if ip4 := ip.To4(); ip4 != nil {
+		address = strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	} else if ip == nil {
+		return "", ErrNoExternalIP
+	}
+
+	return fmt.Sprintf("SEND %s %s %d %d %s", quoteFilename(download.Filename), address, port, download.Size, download.Token), nil
+}
+
+// Accept waits for the sender of a passive offer to connect to the listener
+// and writes the transfer. The listener is closed when it returns. The
+// Connect timeout limits how long to wait for the sender.
+func (download Download) Accept(ctx context.Context, listener net.Listener, writer io.Writer) error {
+	defer listener.Close()
+
+	ctx, cancel := withTimeout(ctx, download.Timeouts.Overall)
+	defer cancel()
+
+	acceptCtx, cancelAccept := withTimeout(ctx, download.Timeouts.Connect)
+	defer cancelAccept()
+	stop := closeOnDone(acceptCtx, listener)
+
+	conn, err := listener.Accept()
+	stop()
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case acceptCtx.Err() != nil:
+			return ErrNoConnection
+		}
+		return err
+	}
+	defer conn.Close()
+
+	return download.receive(ctx, conn, writer)
+}
@@ -0,0 +1,86 @@
+package dcc
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress describes a running transfer.
+type Progress struct {
+	Filename string
+	Received int64
+	Size     int64
+	// Rate is the transfer rate in bytes per second.
+	Rate float64
+	// ETA is the estimated time until the transfer completes. Zero if the
+	// rate isn't known yet.
+	ETA time.Duration
+}
+
+// ProgressWriter counts the bytes written to it and reports the progress of
+// the transfer at most once per interval. The final report is always sent
+// once every byte was received.
+type ProgressWriter struct {
+	mutex    sync.Mutex
+	progress Progress
+	interval time.Duration
+	report   func(Progress)
+	// The time and byte count of the previous report, used for the rate.
+	last         time.Time
+	lastReceived int64
+}
+
+// NewProgressWriter returns a ProgressWriter for a transfer of size bytes
+// that calls report with the progress.
+func NewProgressWriter(filename string, size int64, interval time.Duration, report func(Progress)) *ProgressWriter {
+	return &ProgressWriter{
+		progress: Progress{Filename: filename, Size: size},
+		interval: interval,
+		report:   report,
+		last:     time.Now(),
+	}
+}
+
+// Resume starts counting at offset for a resumed transfer.
+func (p *ProgressWriter) Resume(offset int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.progress.Received = offset
+	p.lastReceived = offset
+}
+
+func (p *ProgressWriter) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	p.progress.Received += int64(len(b))
+
+	now := time.Now()
+	elapsed := now.Sub(p.last)
+	done := p.progress.Received >= p.progress.Size
+	if elapsed < p.interval && !done {
+		p.mutex.Unlock()
+		return len(b), nil
+	}
+
+	if elapsed > 0 {
+		rate := float64(p.progress.Received-p.lastReceived) / elapsed.Seconds()
+		// Smooth the rate so the ETA doesn't jump around between reports.
+		if p.progress.Rate > 0 {
+			rate = (rate + p.progress.Rate) / 2
+		}
+		p.progress.Rate = rate
+	}
+
+	p.progress.ETA = 0
+	if p.progress.Rate > 0 {
+		remaining := float64(p.progress.Size - p.progress.Received)
+		p.progress.ETA = time.Duration(remaining / p.progress.Rate * float64(time.Second))
+	}
+
+	p.last = now
+	p.lastReceived = p.progress.Received
+	progress := p.progress
+	p.mutex.Unlock()
+
+	p.report(progress)
+	return len(b), nil
+}
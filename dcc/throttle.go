@@ -0,0 +1,149 @@
+package dcc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evan-buss/openbooks/util"
+)
+
+// minBurst lets a single read of the receive buffer through without waiting
+// for the bucket to refill, even at low rates.
+const minBurst = 4096
+
+// Throttle limits the bandwidth of DCC transfers and measures their
+// throughput. The global limit is shared by every running transfer and the
+// per-transfer limit applies to each one on its own. The limits can be
+// changed while transfers are running.
+type Throttle struct {
+	global *util.TokenBucket
+
+	mutex       sync.Mutex
+	perTransfer int64
+	transfers   map[*transfer]struct{}
+	// Totals of the finished transfers.
+	finished int
+	received int64
+}
+
+// ThrottleStats are the limits and the throughput of the transfers.
+type ThrottleStats struct {
+	// Limit and TransferLimit are in bytes per second. Zero is unlimited.
+	Limit         int64           `json:"limit"`
+	TransferLimit int64           `json:"transferLimit"`
+	Active        []TransferStats `json:"active"`
+	// Rate is the combined rate of the active transfers in bytes per second.
+	Rate float64 `json:"rate"`
+	// Finished counts the transfers that ended, successful or not.
+	Finished int `json:"finished"`
+	// Received counts the bytes of every transfer, including active ones.
+	Received int64 `json:"received"`
+}
+
+// TransferStats describes a running transfer.
+type TransferStats struct {
+	Filename string  `json:"filename"`
+	Received int64   `json:"received"`
+	Rate     float64 `json:"rate"`
+}
+
+type transfer struct {
+	filename string
+	started  time.Time
+	limit    *util.TokenBucket
+	received int64
+}
+
+// NewThrottle returns a Throttle with the limits in bytes per second. Zero
+// disables a limit.
+func NewThrottle(limit, transferLimit int64) *Throttle {
+	return &Throttle{
+		global:      util.NewTokenBucket(float64(limit), burst(limit)),
+		perTransfer: transferLimit,
+		transfers:   make(map[*transfer]struct{}),
+	}
+}
+
+func burst(limit int64) int {
+	if limit < minBurst {
+		return minBurst
+	}
+	return int(limit)
+}
+
+// SetLimits changes the limits of new and running transfers.
+func (t *Throttle) SetLimits(limit, transferLimit int64) {
+	t.global.SetRate(float64(limit), burst(limit))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.perTransfer = transferLimit
+	for tr := range t.transfers {
+		tr.limit.SetRate(float64(transferLimit), burst(transferLimit))
+	}
+}
+
+// Stats returns the limits and the current throughput.
+func (t *Throttle) Stats() ThrottleStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stats := ThrottleStats{
+		Limit:         int64(t.global.Rate()),
+		TransferLimit: t.perTransfer,
+		Active:        make([]TransferStats, 0, len(t.transfers)),
+		Finished:      t.finished,
+		Received:      t.received,
+	}
+
+	now := time.Now()
+	for tr := range t.transfers {
+		var rate float64
+		if elapsed := now.Sub(tr.started).Seconds(); elapsed > 0 {
+			rate = float64(tr.received) / elapsed
+		}
+		stats.Active = append(stats.Active, TransferStats{Filename: tr.filename, Received: tr.received, Rate: rate})
+		stats.Rate += rate
+		stats.Received += tr.received
+	}
+	sort.Slice(stats.Active, func(i, j int) bool {
+		return stats.Active[i].Filename < stats.Active[j].Filename
+	})
+	return stats
+}
+
+// start registers a transfer. Call done once it ended.
+func (t *Throttle) start(filename string) *transfer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tr := &transfer{
+		filename: filename,
+		started:  time.Now(),
+		limit:    util.NewTokenBucket(float64(t.perTransfer), burst(t.perTransfer)),
+	}
+	t.transfers[tr] = struct{}{}
+	return tr
+}
+
+// wait records n received bytes and blocks until both limits allow them.
+func (t *Throttle) wait(ctx context.Context, tr *transfer, n int) error {
+	t.mutex.Lock()
+	tr.received += int64(n)
+	t.mutex.Unlock()
+
+	if err := tr.limit.Wait(ctx, n); err != nil {
+		return err
+	}
+	return t.global.Wait(ctx, n)
+}
+
+func (t *Throttle) done(tr *transfer) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.transfers, tr)
+	t.finished++
+	t.received += tr.received
+}
@@ -0,0 +1,86 @@
+package dcc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	ErrStalled      = errors.New("dcc transfer stalled. the sender stopped sending data")
+	ErrNoConnection = errors.New("the sender didn't connect for the passive dcc transfer")
+)
+
+// Timeouts limit how long a transfer may take. A zero duration disables the
+// limit.
+type Timeouts struct {
+	// Connect limits connecting to the sender, or waiting for the sender to
+	// connect to us for passive offers.
+	Connect time.Duration
+	// Idle limits how long the sender may go without sending any data.
+	Idle time.Duration
+	// Overall limits the whole transfer, including connecting.
+	Overall time.Duration
+}
+
+// DefaultTimeouts are used unless configured otherwise.
+var DefaultTimeouts = Timeouts{
+	Connect: 30 * time.Second,
+	Idle:    2 * time.Minute,
+	Overall: time.Hour,
+}
+
+// Config holds the settings shared by every transfer.
+type Config struct {
+	Passive  Passive
+	Timeouts Timeouts
+	// AllowPrivate allows connecting to senders that advertise a private,
+	// loopback or link-local address. Offers like that are refused by
+	// default because they make us connect to hosts on our own network.
+	AllowPrivate bool
+	// MaxSize declines offers of larger files, in bytes. Zero allows any
+	// size.
+	MaxSize int64
+	// Quota limits the total size of the download directory, in bytes. Zero
+	// disables the quota.
+	Quota int64
+	// Throttle limits the bandwidth of the transfers. Optional.
+	Throttle *Throttle
+}
+
+// withTimeout is context.WithTimeout that treats a zero timeout as no limit.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// closeOnDone closes c once the context is done. This unblocks reads and
+// accepts that don't take a context. The returned function stops watching.
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// readError explains why a read from the sender failed.
+func readError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrStalled
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package irc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrAuthFailed = errors.New("authentication failed")
+
+// AuthMethod selects how the connection identifies with network services.
+type AuthMethod int
+
+const (
+	// AuthNone doesn't identify with services.
+	AuthNone AuthMethod = iota
+	// AuthNickServ sends IDENTIFY to NickServ once registration completes.
+	AuthNickServ
+	// AuthSASL uses SASL PLAIN during registration. NickServ IDENTIFY is used
+	// instead if the server doesn't support SASL.
+	AuthSASL
+	// AuthExternal uses SASL EXTERNAL, which identifies with the client
+	// certificate from TLSOptions. NickServ IDENTIFY is used if the server
+	// doesn't support it and a NickServPassword is set.
+	AuthExternal
+)
+
+// ParseAuthMethod converts a method name ("none", "nickserv", "sasl",
+// "external") to an AuthMethod.
+func ParseAuthMethod(name string) (AuthMethod, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return AuthNone, nil
+	case "nickserv":
+		return AuthNickServ, nil
+	case "sasl":
+		return AuthSASL, nil
+	case "external":
+		return AuthExternal, nil
+	}
+	return AuthNone, fmt.Errorf("unknown authentication method %q", name)
+}
+
+// identifyTimeout limits how long we wait for NickServ to respond to IDENTIFY.
+const identifyTimeout = 15 * time.Second
+
+// SASL related numeric replies.
+const (
+	rplLoggedIn    = "900"
+	errNickLocked  = "902"
+	rplSaslSuccess = "903"
+	errSaslFail    = "904"
+	errSaslTooLong = "905"
+	errSaslAborted = "906"
+)
+
+// Phrases used by the common services packages in response to IDENTIFY.
+var (
+	identifySuccess = []string{"you are now identified", "you are now recognized", "you are now logged in", "password accepted"}
+	identifyFailure = []string{"invalid password", "password incorrect", "not registered", "isn't registered", "access denied", "authentication failed"}
+)
+
+// account returns the services account name, which defaults to Username.
+func (i *Conn) account() string {
+	if i.Account != "" {
+		return i.Account
+	}
+	return i.Username
+}
+
+// useSASL returns true if CAP negotiation is needed to authenticate.
+func (i *Conn) useSASL() bool {
+	return (i.Auth == AuthSASL && i.NickServPassword != "") || i.Auth == AuthExternal
+}
+
+// saslMechanism returns the SASL mechanism used by the Auth method.
+func (i *Conn) saslMechanism() string {
+	if i.Auth == AuthExternal {
+		return "EXTERNAL"
+	}
+	return "PLAIN"
+}
+
+// saslNegotiator follows the IRCv3 CAP and SASL exchange during registration.
+type saslNegotiator struct {
+	conn *Conn
+	// mechanism is either PLAIN or EXTERNAL.
+	mechanism string
+	// caps holds the capabilities advertised so far by CAP LS.
+	caps []string
+	// authenticated is true once the server reports SASL success. Otherwise
+	// we identify with NickServ after registration.
+	authenticated bool
+}
+
+// handle processes a registration message. It returns true if the message was
+// part of the SASL exchange.
+func (s *saslNegotiator) handle(msg *Message) (bool, error) {
+	switch msg.Command {
+	case "CAP":
+		switch strings.ToUpper(msg.Param(1)) {
+		case "LS":
+			s.caps = append(s.caps, strings.Fields(msg.Last())...)
+			// A "*" parameter means the list continues on the next line.
+			if msg.Param(2) == "*" {
+				return true, nil
+			}
+			if s.supported() {
+				s.conn.send("CAP REQ :sasl")
+			} else {
+				s.conn.send("CAP END")
+			}
+		case "ACK":
+			s.conn.send("AUTHENTICATE " + s.mechanism)
+		case "NAK":
+			s.conn.send("CAP END")
+		}
+		return true, nil
+	case "AUTHENTICATE":
+		if msg.Last() != "+" {
+			return true, nil
+		}
+		// EXTERNAL takes the identity from the client certificate.
+		if s.mechanism == "EXTERNAL" {
+			s.conn.send("AUTHENTICATE +")
+		} else {
+			s.sendCredentials()
+		}
+		return true, nil
+	case rplSaslSuccess:
+		s.authenticated = true
+		s.conn.send("CAP END")
+		return true, nil
+	case rplLoggedIn:
+		return true, nil
+	case errNickLocked, errSaslFail, errSaslTooLong, errSaslAborted:
+		return true, &RegistrationError{Err: ErrAuthFailed, Code: msg.Command, Reason: msg.Last()}
+	}
+	return false, nil
+}
+
+func (s *saslNegotiator) supported() bool {
+	for _, capability := range s.caps {
+		name, mechanisms, _ := strings.Cut(capability, "=")
+		if name != "sasl" {
+			continue
+		}
+		if mechanisms == "" {
+			return true
+		}
+		for _, mechanism := range strings.Split(mechanisms, ",") {
+			if mechanism == s.mechanism {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// sendCredentials sends the base64 encoded PLAIN payload in 400 byte chunks.
+func (s *saslNegotiator) sendCredentials() {
+	account := s.conn.account()
+	payload := base64.StdEncoding.EncodeToString([]byte(account + "\x00" + account + "\x00" + s.conn.NickServPassword))
+
+	for len(payload) >= 400 {
+		s.conn.send("AUTHENTICATE " + payload[:400])
+		payload = payload[400:]
+	}
+	if payload == "" {
+		payload = "+"
+	}
+	s.conn.send("AUTHENTICATE " + payload)
+}
+
+// identify sends IDENTIFY to NickServ and waits for the result.
+func (i *Conn) identify(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, identifyTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	i.SetReadDeadline(deadline)
+
+	i.send("PRIVMSG NickServ :IDENTIFY " + i.account() + " " + i.NickServPassword)
+
+	err := i.readUntil(ctx, func(msg *Message) (bool, error) {
+		if msg.Command == rplLoggedIn {
+			return true, nil
+		}
+		if msg.Command != "NOTICE" || !strings.EqualFold(msg.Prefix.Nick, "NickServ") {
+			return false, nil
+		}
+
+		text := strings.ToLower(msg.Last())
+		for _, phrase := range identifySuccess {
+			if strings.Contains(text, phrase) {
+				return true, nil
+			}
+		}
+		for _, phrase := range identifyFailure {
+			if strings.Contains(text, phrase) {
+				return false, &RegistrationError{Err: ErrAuthFailed, Code: "NOTICE", Reason: msg.Last()}
+			}
+		}
+		return false, nil
+	})
+
+	if errors.Is(err, ErrRegistrationTimeout) {
+		return &RegistrationError{Err: ErrAuthFailed, Reason: "NickServ did not respond to IDENTIFY"}
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package irc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evan-buss/openbooks/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	saslServerAddress   = "localhost:6681"
+	legacyServerAddress = "localhost:6682"
+)
+
+var startServers sync.Once
+
+// startMockServers starts one mock server that supports SASL and one that
+// only supports NickServ.
+func startMockServers() {
+	startServers.Do(func() {
+		accounts := map[string]string{"evan_bot": "hunter2"}
+		ready := make(chan struct{})
+
+		sasl := mock.IrcServer{Port: ":6681", SASL: true, Accounts: accounts}
+		go sasl.Start(ready)
+		<-ready
+
+		legacy := mock.IrcServer{Port: ":6682", Accounts: accounts}
+		go legacy.Start(ready)
+		<-ready
+	})
+}
+
+func connectAndRegister(t *testing.T, address string, auth AuthMethod, password string) error {
+	startMockServers()
+
+	conn := New("evan_bot", "OpenBooks")
+	conn.Auth = auth
+	conn.NickServPassword = password
+
+	require.NoError(t, conn.Connect(address, false))
+	t.Cleanup(conn.Disconnect)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return conn.Register(ctx)
+}
+
+func TestAuthentication(t *testing.T) {
+	cases := []struct {
+		reason   string
+		address  string
+		auth     AuthMethod
+		password string
+		wantErr  error
+	}{
+		{"sasl plain", saslServerAddress, AuthSASL, "hunter2", nil},
+		{"sasl plain wrong password", saslServerAddress, AuthSASL, "letmein", ErrAuthFailed},
+		{"nickserv identify", legacyServerAddress, AuthNickServ, "hunter2", nil},
+		{"nickserv identify wrong password", legacyServerAddress, AuthNickServ, "letmein", ErrAuthFailed},
+		{"sasl falls back to nickserv", legacyServerAddress, AuthSASL, "hunter2", nil},
+		{"sasl fallback wrong password", legacyServerAddress, AuthSASL, "letmein", ErrAuthFailed},
+	}
+
+	for _, c := range cases {
+		err := connectAndRegister(t, c.address, c.auth, c.password)
+		if c.wantErr == nil {
+			assert.NoError(t, err, c.reason)
+		} else {
+			assert.ErrorIs(t, err, c.wantErr, c.reason)
+		}
+	}
+}
+
+func TestParseAuthMethod(t *testing.T) {
+	method, err := ParseAuthMethod("SASL")
+	require.NoError(t, err)
+	assert.Equal(t, AuthSASL, method)
+
+	method, err = ParseAuthMethod("external")
+	require.NoError(t, err)
+	assert.Equal(t, AuthExternal, method)
+
+	_, err = ParseAuthMethod("kerberos")
+	assert.Error(t, err)
+}
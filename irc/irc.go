@@ -1,104 +1,320 @@
 package irc
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"net"
+	"strings"
+	"sync"
+
+	"github.com/evan-buss/openbooks/proxy"
+	"github.com/evan-buss/openbooks/util"
+)
+
+var (
+	ErrClosed    = errors.New("connection was closed with Disconnect")
+	ErrNoChannel = errors.New("no channel joined")
 )
 
 // Conn represents an IRC connection to a server
 type Conn struct {
 	net.Conn
-	channel  string
+	reader   *bufio.Reader
 	Username string
 	realname string
+
+	// NickStrategy picks the nicknames to try if Username is already in use.
+	NickStrategy NickStrategy
+	// NickServPassword is the services account password. It is used to
+	// authenticate and to reclaim Username with GHOST when we had to fall back
+	// to an alternative nickname.
+	NickServPassword string
+	// Auth selects how to authenticate with NickServPassword.
+	Auth AuthMethod
+	// Account is the services account name. Defaults to Username.
+	Account string
+	// TLS configures certificate verification when connecting with TLS.
+	TLS TLSOptions
+	// Dialer opens the connection to the server, optionally through a proxy.
+	// Defaults to proxy.Direct.
+	Dialer proxy.Dialer
+
+	// nick is the nickname accepted by the server.
+	nick      string
+	nickMutex sync.Mutex
+
+	// Address and TLS setting of the last Connect call, used to reconnect.
+	address   string
+	enableTLS bool
+
+	// connMutex guards swapping the underlying connection and writes to it.
+	connMutex sync.Mutex
+	// closed is set by Disconnect to prevent any further reconnects.
+	closed bool
+
+	// channels holds the joined channels. Messages are sent to the first.
+	channels     []string
+	channelMutex sync.Mutex
+
+	// Lines waiting for the writer goroutine. Ping replies go through the
+	// priority queue so they aren't delayed by the rate limiter.
+	priority   chan string
+	queue      chan string
+	limiter    *util.TokenBucket
+	stop       chan struct{}
+	writerOnce sync.Once
 }
 
 // New creates a new IRC connection to the server using the supplied username and realname
 func New(username, realname string) *Conn {
 	irc := &Conn{
-		channel:  "",
 		Username: username,
 		realname: realname,
+		priority: make(chan string, queueSize),
+		queue:    make(chan string, queueSize),
+		limiter:  newLimiter(),
+		stop:     make(chan struct{}),
 	}
 
 	return irc
 }
 
-// Connect connects to the given server at port 6667
+// Connect connects to the given server at port 6667. A *CertificateError is
+// returned if the server's TLS certificate can't be verified.
 func (i *Conn) Connect(address string, enableTLS bool) error {
-	var conn net.Conn
-	var err error
+	return i.ConnectContext(context.Background(), address, enableTLS)
+}
+
+// ConnectContext is like Connect. The context limits opening the connection,
+// the proxy handshake and the TLS handshake.
+func (i *Conn) ConnectContext(ctx context.Context, address string, enableTLS bool) error {
+	var config *tls.Config
 	if enableTLS {
-		conn, err = tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		conn, err = net.Dial("tcp", address)
+		var err error
+		config, err = i.TLS.config(address)
+		if err != nil {
+			return err
+		}
 	}
 
+	conn, err := proxy.Dial(ctx, i.Dialer, address)
 	if err != nil {
 		return err
 	}
 
+	if enableTLS {
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			if isCertificateError(err) {
+				return &CertificateError{Address: address, Err: err}
+			}
+			return err
+		}
+		conn = tlsConn
+	}
+
+	if err := i.attach(conn); err != nil {
+		return err
+	}
+	i.address = address
+	i.enableTLS = enableTLS
+	i.setNick(i.Username)
+	i.startWriter()
+
+	// Registration is written directly. Nothing else may be sent before the
+	// server has accepted it.
+	if i.useSASL() {
+		i.send("CAP LS 302")
+	}
+	i.send("USER " + i.Username + " 0 * :" + i.Username)
+	i.send("NICK " + i.Username)
+	return nil
+}
+
+// attach uses conn as the underlying connection. All reads go through a
+// buffered reader so that lines consumed during registration don't cut off the
+// data that follows.
+func (i *Conn) attach(conn net.Conn) error {
+	i.connMutex.Lock()
+	defer i.connMutex.Unlock()
+
+	if i.closed {
+		conn.Close()
+		return ErrClosed
+	}
+
 	i.Conn = conn
+	i.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Reconnect replaces a dropped connection. It connects to the address used
+// by the last Connect call, registers and rejoins the channels.
+func (i *Conn) Reconnect(ctx context.Context) error {
+	if i.Closed() {
+		return ErrClosed
+	}
+
+	i.connMutex.Lock()
+	if i.Conn != nil {
+		i.Conn.Close()
+	}
+	i.connMutex.Unlock()
 
-	user := "USER " + i.Username + " 0 * :" + i.Username + "\r\n"
-	nick := "NICK " + i.Username + "\r\n"
+	err := i.ConnectContext(ctx, i.address, i.enableTLS)
+	if err != nil {
+		return err
+	}
+
+	err = i.Register(ctx)
+	if err != nil {
+		i.connMutex.Lock()
+		i.Conn.Close()
+		i.connMutex.Unlock()
+		return err
+	}
 
-	i.Write([]byte(user))
-	i.Write([]byte(nick))
+	// The server replies to JOIN with the channel's NAMES list so the list of
+	// users is refreshed as well.
+	for _, channel := range i.Channels() {
+		err = i.enqueue(ctx, "JOIN #"+channel, false)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Disconnect closes connection to the IRC server
-func (i *Conn) Disconnect() {
-	if !i.IsConnected() {
-		return
+// Read reads raw data from the server.
+func (i *Conn) Read(b []byte) (int, error) {
+	if i.reader == nil {
+		return i.Conn.Read(b)
 	}
-	i.Write([]byte("QUIT :Goodbye\r\n"))
-	i.Conn.Close()
+	return i.reader.Read(b)
 }
 
-// SendMessage sends the given message string to the connected IRC server
-func (i *Conn) SendMessage(message string) {
-	if !i.IsConnected() {
-		return
+// readLine reads a single line from the server without the trailing CR LF.
+func (i *Conn) readLine() (string, error) {
+	line, err := i.reader.ReadString('\n')
+	if err != nil {
+		return "", err
 	}
-	i.Write([]byte("PRIVMSG #" + i.channel + " :" + message + "\r\n"))
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// send writes a single line to the server. It bypasses the write queue and
+// is only used during registration and by the writer goroutine.
+func (i *Conn) send(line string) error {
+	i.connMutex.Lock()
+	defer i.connMutex.Unlock()
+	_, err := i.Write([]byte(line + "\r\n"))
+	return err
 }
 
-// SendNotice sends a notice message to the specified user
-func (i *Conn) SendNotice(user string, message string) {
-	if !i.IsConnected() {
+// Disconnect closes connection to the IRC server. The connection can't be
+// reconnected afterwards. Lines that are still queued are discarded.
+func (i *Conn) Disconnect() {
+	if i.IsConnected() {
+		i.send("QUIT :Goodbye")
+	}
+
+	i.connMutex.Lock()
+	defer i.connMutex.Unlock()
+	if i.closed {
 		return
 	}
-	i.Write([]byte("NOTICE " + user + " :" + message + "\r\n"))
+	i.closed = true
+	close(i.stop)
+	if i.Conn != nil {
+		i.Conn.Close()
+	}
 }
 
-// JoinChannel joins the channel given by channel string
-func (i *Conn) JoinChannel(channel string) {
-	if !i.IsConnected() {
-		return
+// Closed returns true once Disconnect has been called.
+func (i *Conn) Closed() bool {
+	i.connMutex.Lock()
+	defer i.connMutex.Unlock()
+	return i.closed
+}
+
+// SendMessage queues the given message to the first joined channel
+func (i *Conn) SendMessage(ctx context.Context, message string) error {
+	channels := i.Channels()
+	if len(channels) == 0 {
+		return ErrNoChannel
 	}
-	i.channel = channel
-	i.Write([]byte("JOIN #" + channel + "\r\n"))
+	return i.SendMessageTo(ctx, channels[0], message)
 }
 
-// GetUsers sends a NAMES request to the IRC server
-func (i *Conn) GetUsers(channel string) {
-	if !i.IsConnected() {
-		return
+// SendMessageTo queues the given message to a specific channel
+func (i *Conn) SendMessageTo(ctx context.Context, channel string, message string) error {
+	return i.enqueue(ctx, "PRIVMSG #"+channel+" :"+message, false)
+}
+
+// SendNotice queues a notice message to the specified user
+func (i *Conn) SendNotice(ctx context.Context, user string, message string) error {
+	return i.enqueue(ctx, "NOTICE "+user+" :"+message, false)
+}
+
+// SendCTCP queues a client-to-client protocol request to the specified user
+func (i *Conn) SendCTCP(ctx context.Context, user string, command string, args string) error {
+	if args != "" {
+		command += " " + args
 	}
-	i.Write([]byte("NAMES #" + channel + "\r\n"))
+	return i.enqueue(ctx, "PRIVMSG "+user+" :\x01"+command+"\x01", false)
 }
 
-// Pong sends a Pong message to the server, often used after a PING request
-func (i *Conn) Pong(server string) {
-	if !i.IsConnected() {
-		return
+// JoinChannel joins the channel given by channel string. The channel is
+// joined again after a reconnect.
+func (i *Conn) JoinChannel(ctx context.Context, channel string) error {
+	channel = strings.TrimPrefix(channel, "#")
+	err := i.enqueue(ctx, "JOIN #"+channel, false)
+	if err != nil {
+		return err
+	}
+
+	i.channelMutex.Lock()
+	defer i.channelMutex.Unlock()
+	for _, joined := range i.channels {
+		if strings.EqualFold(joined, channel) {
+			return nil
+		}
 	}
-	i.Write([]byte("PONG " + server + "\r\n"))
+	i.channels = append(i.channels, channel)
+	return nil
+}
+
+// Channels returns the joined channels without the leading "#".
+func (i *Conn) Channels() []string {
+	i.channelMutex.Lock()
+	defer i.channelMutex.Unlock()
+	return append([]string(nil), i.channels...)
+}
+
+// GetUsers sends a NAMES request to the IRC server
+func (i *Conn) GetUsers(ctx context.Context, channel string) error {
+	return i.enqueue(ctx, "NAMES #"+channel, false)
+}
+
+// GetUserHost sends a USERHOST request for nick to the IRC server
+func (i *Conn) GetUserHost(ctx context.Context, nick string) error {
+	return i.enqueue(ctx, "USERHOST "+nick, false)
+}
+
+// Pong sends a Pong message to the server, often used after a PING request.
+// The token must match the one received in the PING. Pongs skip the queue so
+// the server doesn't time us out while the queue is full.
+func (i *Conn) Pong(ctx context.Context, token string) error {
+	return i.enqueue(ctx, "PONG :"+token, true)
 }
 
 // IsConnected returns true if the IRC connection is not null
 func (i *Conn) IsConnected() bool {
+	i.connMutex.Lock()
+	defer i.connMutex.Unlock()
 	return i.Conn != nil
 }
@@ -0,0 +1,156 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrEmptyMessage   = errors.New("empty irc message")
+	ErrMissingCommand = errors.New("irc message is missing a command")
+)
+
+// Prefix identifies the origin of a message. Messages sent by the server only
+// have a Nick, which holds the server name.
+type Prefix struct {
+	Nick string
+	User string
+	Host string
+}
+
+// IsServer returns true if the message originated from a server rather than
+// another user.
+func (p Prefix) IsServer() bool {
+	return p.User == "" && p.Host == "" && strings.Contains(p.Nick, ".")
+}
+
+func (p Prefix) String() string {
+	prefix := p.Nick
+	if p.User != "" {
+		prefix += "!" + p.User
+	}
+	if p.Host != "" {
+		prefix += "@" + p.Host
+	}
+	return prefix
+}
+
+// Message is a single line of the IRC protocol split into its components.
+//
+//	[@tags] [:nick!user@host] COMMAND [params...] [:trailing]
+type Message struct {
+	Tags     map[string]string
+	Prefix   Prefix
+	Command  string
+	Params   []string
+	Trailing string
+	// HasTrailing is true when the line contained a trailing parameter, even
+	// if it was empty.
+	HasTrailing bool
+}
+
+// ParseMessage parses a raw IRC line. The trailing CR LF is optional.
+func ParseMessage(line string) (*Message, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	msg := &Message{}
+
+	if line[0] == '@' {
+		var tags string
+		tags, line = splitWord(line[1:])
+		msg.Tags = parseTags(tags)
+	}
+
+	if strings.HasPrefix(line, ":") {
+		var prefix string
+		prefix, line = splitWord(line[1:])
+		msg.Prefix = parsePrefix(prefix)
+	}
+
+	msg.Command, line = splitWord(line)
+	if msg.Command == "" {
+		return nil, ErrMissingCommand
+	}
+	msg.Command = strings.ToUpper(msg.Command)
+
+	for line != "" {
+		if line[0] == ':' {
+			msg.Trailing = line[1:]
+			msg.HasTrailing = true
+			break
+		}
+
+		var param string
+		param, line = splitWord(line)
+		msg.Params = append(msg.Params, param)
+	}
+
+	return msg, nil
+}
+
+// Param returns the parameter at index i or an empty string if it doesn't exist.
+// The trailing parameter is not included.
+func (m *Message) Param(i int) string {
+	if i < 0 || i >= len(m.Params) {
+		return ""
+	}
+	return m.Params[i]
+}
+
+// Last returns the final parameter of the message. This is the trailing
+// parameter if one was sent.
+func (m *Message) Last() string {
+	if m.HasTrailing || len(m.Params) == 0 {
+		return m.Trailing
+	}
+	return m.Params[len(m.Params)-1]
+}
+
+// CTCP extracts a client-to-client protocol request that is embedded in a
+// PRIVMSG or NOTICE. For "\x01DCC SEND file 1 2 3\x01" it returns "DCC" and
+// "SEND file 1 2 3".
+func (m *Message) CTCP() (command, args string, ok bool) {
+	if m.Command != "PRIVMSG" && m.Command != "NOTICE" {
+		return "", "", false
+	}
+
+	text := m.Last()
+	if !strings.HasPrefix(text, "\x01") {
+		return "", "", false
+	}
+
+	text = strings.TrimSuffix(text[1:], "\x01")
+	command, args, _ = strings.Cut(text, " ")
+	return strings.ToUpper(command), args, command != ""
+}
+
+// splitWord returns the text before the first space and the remainder with
+// leading spaces removed.
+func splitWord(s string) (string, string) {
+	word, rest, _ := strings.Cut(s, " ")
+	return word, strings.TrimLeft(rest, " ")
+}
+
+func parsePrefix(raw string) Prefix {
+	var prefix Prefix
+	raw, prefix.Host, _ = strings.Cut(raw, "@")
+	prefix.Nick, prefix.User, _ = strings.Cut(raw, "!")
+	return prefix
+}
+
+var tagEscapes = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = tagEscapes.Replace(value)
+	}
+	return tags
+}
@@ -0,0 +1,102 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessage(t *testing.T) {
+	cases := []struct {
+		line string
+		want *Message
+	}{
+		{
+			"PING :irc.irchighway.net",
+			&Message{Command: "PING", Trailing: "irc.irchighway.net", HasTrailing: true},
+		},
+		{
+			":irc.irchighway.net 001 evan_bot :Welcome to the IRC Highway IRC Network evan_bot!evan_bot@ihw-3a7.example.net\r\n",
+			&Message{
+				Prefix:      Prefix{Nick: "irc.irchighway.net"},
+				Command:     "001",
+				Params:      []string{"evan_bot"},
+				Trailing:    "Welcome to the IRC Highway IRC Network evan_bot!evan_bot@ihw-3a7.example.net",
+				HasTrailing: true,
+			},
+		},
+		{
+			":irc.irchighway.net 353 evan_bot = #ebooks :evan_bot ~DV8 +Oatmeal @Horla",
+			&Message{
+				Prefix:      Prefix{Nick: "irc.irchighway.net"},
+				Command:     "353",
+				Params:      []string{"evan_bot", "=", "#ebooks"},
+				Trailing:    "evan_bot ~DV8 +Oatmeal @Horla",
+				HasTrailing: true,
+			},
+		},
+		{
+			":Search!Search@ihw-4q5hcb.dyn.suddenlink.net PRIVMSG evan_bot :\x01DCC SEND SearchBot_results_for__stephen_king_the_stand.txt.zip 2907707975 4342 1116\x01",
+			&Message{
+				Prefix:      Prefix{Nick: "Search", User: "Search", Host: "ihw-4q5hcb.dyn.suddenlink.net"},
+				Command:     "PRIVMSG",
+				Params:      []string{"evan_bot"},
+				Trailing:    "\x01DCC SEND SearchBot_results_for__stephen_king_the_stand.txt.zip 2907707975 4342 1116\x01",
+				HasTrailing: true,
+			},
+		},
+		{
+			"@time=2022-09-01T12:00:00.000Z;msgid=a\\sb\\:c :evan!evan@host MODE #ebooks +v Oatmeal",
+			&Message{
+				Tags:    map[string]string{"time": "2022-09-01T12:00:00.000Z", "msgid": "a b;c"},
+				Prefix:  Prefix{Nick: "evan", User: "evan", Host: "host"},
+				Command: "MODE",
+				Params:  []string{"#ebooks", "+v", "Oatmeal"},
+			},
+		},
+		{
+			"notice  evan_bot  :*** Looking up your hostname...",
+			&Message{
+				Command:     "NOTICE",
+				Params:      []string{"evan_bot"},
+				Trailing:    "*** Looking up your hostname...",
+				HasTrailing: true,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		msg, err := ParseMessage(c.line)
+		require.NoError(t, err, c.line)
+		assert.Equal(t, c.want, msg, c.line)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	_, err := ParseMessage("\r\n")
+	assert.ErrorIs(t, err, ErrEmptyMessage)
+
+	_, err = ParseMessage(":irc.irchighway.net")
+	assert.ErrorIs(t, err, ErrMissingCommand)
+}
+
+func TestMessageHelpers(t *testing.T) {
+	msg, err := ParseMessage(":DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu PRIVMSG negative-bishop-1 :\x01DCC SEND \"Hitchhiker's Guide.rar\" 2760158537 2050 2321788\x01")
+	require.NoError(t, err)
+
+	command, args, ok := msg.CTCP()
+	assert.True(t, ok)
+	assert.Equal(t, "DCC", command)
+	assert.Equal(t, "SEND \"Hitchhiker's Guide.rar\" 2760158537 2050 2321788", args)
+	assert.False(t, msg.Prefix.IsServer())
+	assert.Equal(t, "DV8!HandyAndy@ihw-39fkft.ip-164-132-173.eu", msg.Prefix.String())
+
+	ping, err := ParseMessage("PING LagCheck")
+	require.NoError(t, err)
+	assert.Equal(t, "LagCheck", ping.Last())
+	assert.Equal(t, "", ping.Param(3))
+
+	_, _, ok = ping.CTCP()
+	assert.False(t, ok)
+}
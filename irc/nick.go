@@ -0,0 +1,70 @@
+package irc
+
+import "strconv"
+
+// NickStrategy returns the alternative nicknames to try, in order, when the
+// server reports that nick is already in use.
+type NickStrategy func(nick string) []string
+
+// SuffixDigits appends the numbers 1 through max to the nickname.
+// Ex) evan -> evan1, evan2, evan3
+func SuffixDigits(max int) NickStrategy {
+	return func(nick string) []string {
+		nicks := make([]string, 0, max)
+		for i := 1; i <= max; i++ {
+			nicks = append(nicks, nick+strconv.Itoa(i))
+		}
+		return nicks
+	}
+}
+
+// FallbackNicks tries each of the given nicknames in order.
+func FallbackNicks(fallbacks ...string) NickStrategy {
+	return func(_ string) []string {
+		return fallbacks
+	}
+}
+
+// ChainNicks tries the nicknames from each strategy in the order given.
+func ChainNicks(strategies ...NickStrategy) NickStrategy {
+	return func(nick string) []string {
+		var nicks []string
+		for _, strategy := range strategies {
+			if strategy != nil {
+				nicks = append(nicks, strategy(nick)...)
+			}
+		}
+		return nicks
+	}
+}
+
+// alternativeNicks returns the nicknames to try when Username is taken.
+// If a NickServ password is set but no strategy, a single underscore suffix
+// is used so that we can register and then reclaim the nickname.
+func (i *Conn) alternativeNicks() []string {
+	if i.NickStrategy != nil {
+		return i.NickStrategy(i.Username)
+	}
+	if i.NickServPassword != "" {
+		return []string{i.Username + "_"}
+	}
+	return nil
+}
+
+// Nick returns the nickname the server accepted. Before registration has
+// completed this is the requested Username.
+func (i *Conn) Nick() string {
+	i.nickMutex.Lock()
+	defer i.nickMutex.Unlock()
+
+	if i.nick == "" {
+		return i.Username
+	}
+	return i.nick
+}
+
+func (i *Conn) setNick(nick string) {
+	i.nickMutex.Lock()
+	defer i.nickMutex.Unlock()
+	i.nick = nick
+}
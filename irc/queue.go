@@ -0,0 +1,113 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/evan-buss/openbooks/util"
+)
+
+// ErrNotConnected is returned when sending before Connect was called.
+var ErrNotConnected = errors.New("not connected to a server")
+
+// Outgoing lines are limited to a burst of floodBurst lines followed by one
+// line every floodInterval. This stays below the excess flood limits of
+// common IRC servers.
+const (
+	floodBurst    = 5
+	floodInterval = 2 * time.Second
+	queueSize     = 64
+)
+
+// newLimiter returns the rate limiter for lines sent through the queue.
+func newLimiter() *util.TokenBucket {
+	return util.NewTokenBucket(float64(time.Second)/float64(floodInterval), floodBurst)
+}
+
+// enqueue adds a line to the write queue. Priority lines skip the queue and
+// aren't rate limited. It blocks while the queue is full.
+func (i *Conn) enqueue(ctx context.Context, line string, priority bool) error {
+	if i.Closed() {
+		return ErrClosed
+	}
+	if !i.IsConnected() {
+		return ErrNotConnected
+	}
+
+	queue := i.queue
+	if priority {
+		queue = i.priority
+	}
+
+	select {
+	case queue <- line:
+		return nil
+	case <-i.stop:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueueLen returns the number of lines waiting to be sent.
+func (i *Conn) QueueLen() int {
+	return len(i.priority) + len(i.queue)
+}
+
+// startWriter starts the goroutine that writes queued lines. It runs until
+// Disconnect is called and keeps running across reconnects.
+func (i *Conn) startWriter() {
+	i.writerOnce.Do(func() {
+		go i.writeLoop()
+	})
+}
+
+// writeLoop is the only writer of queued lines so that they never interleave.
+// Priority lines are written as soon as they arrive, even while a regular
+// line is waiting for the rate limiter.
+func (i *Conn) writeLoop() {
+	for {
+		select {
+		case line := <-i.priority:
+			i.send(line)
+			continue
+		default:
+		}
+
+		select {
+		case line := <-i.priority:
+			i.send(line)
+		case line := <-i.queue:
+			if !i.waitForToken() {
+				return
+			}
+			i.send(line)
+		case <-i.stop:
+			return
+		}
+	}
+}
+
+// waitForToken waits until the rate limiter allows the next line, writing
+// priority lines in the meantime. It returns false once the writer stops.
+func (i *Conn) waitForToken() bool {
+	delay := i.limiter.Reserve(1)
+	if delay == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case line := <-i.priority:
+			i.send(line)
+		case <-timer.C:
+			return true
+		case <-i.stop:
+			return false
+		}
+	}
+}
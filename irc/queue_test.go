@@ -0,0 +1,58 @@
+package irc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteQueue(t *testing.T) {
+	conn, received := scriptedConn(t)
+	defer conn.Disconnect()
+	ctx := context.Background()
+
+	// Queue more lines than the burst allows before the writer starts.
+	for i := 0; i < floodBurst+1; i++ {
+		require.NoError(t, conn.SendMessageTo(ctx, "ebooks", "@search gatsby"))
+	}
+	require.NoError(t, conn.Pong(ctx, "3A0F5C21"))
+	assert.Equal(t, floodBurst+2, conn.QueueLen())
+
+	conn.startWriter()
+
+	assert.Equal(t, "PONG :3A0F5C21", <-received, "pong skips the queue")
+	for i := 0; i < floodBurst; i++ {
+		assert.Equal(t, "PRIVMSG #ebooks :@search gatsby", <-received)
+	}
+
+	select {
+	case line := <-received:
+		t.Fatalf("line sent before the rate limit allowed it: %s", line)
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Equal(t, 0, conn.QueueLen(), "last line is waiting for the rate limiter")
+}
+
+func TestWriteQueueClosed(t *testing.T) {
+	conn := New("evan_bot", "OpenBooks")
+	assert.ErrorIs(t, conn.SendMessageTo(context.Background(), "ebooks", "hello"), ErrNotConnected)
+
+	conn, _ = scriptedConn(t)
+	conn.Disconnect()
+	assert.ErrorIs(t, conn.Pong(context.Background(), "token"), ErrClosed)
+}
+
+func TestSendCTCP(t *testing.T) {
+	conn, received := scriptedConn(t)
+	defer conn.Disconnect()
+	conn.startWriter()
+	ctx := context.Background()
+
+	require.NoError(t, conn.SendCTCP(ctx, "Oatmeal", "VERSION", ""))
+	assert.Equal(t, "PRIVMSG Oatmeal :\x01VERSION\x01", <-received)
+	require.NoError(t, conn.SendCTCP(ctx, "Oatmeal", "DCC", "RESUME file.epub 0 1024"))
+	assert.Equal(t, "PRIVMSG Oatmeal :\x01DCC RESUME file.epub 0 1024\x01", <-received)
+}
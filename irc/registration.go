@@ -0,0 +1,196 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNicknameInUse       = errors.New("nickname is already in use")
+	ErrErroneousNickname   = errors.New("nickname is not valid")
+	ErrBanned              = errors.New("banned from the server")
+	ErrServerClosed        = errors.New("server closed the connection")
+	ErrRegistrationTimeout = errors.New("timed out waiting for the server to accept the connection")
+)
+
+// reclaimTimeout limits how long we wait for NickServ before keeping the
+// alternative nickname.
+const reclaimTimeout = 10 * time.Second
+
+// Numeric replies that are relevant during connection registration.
+const (
+	rplWelcome          = "001"
+	errErroneusNickname = "432"
+	errNicknameInUse    = "433"
+	errNickCollision    = "436"
+	errUnavailResource  = "437"
+	errYoureBannedCreep = "465"
+)
+
+// RegistrationError is returned when the server refuses the connection during
+// registration. Use errors.Is with the Err* values to check the cause.
+type RegistrationError struct {
+	Err error
+	// Code is the numeric reply or command (ERROR) that rejected the connection.
+	Code string
+	// Reason is the explanation sent by the server.
+	Reason string
+}
+
+func (e *RegistrationError) Error() string {
+	if e.Reason == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+}
+
+func (e *RegistrationError) Unwrap() error {
+	return e.Err
+}
+
+// Register waits for the server to accept the USER and NICK commands sent by
+// Connect. It returns once RPL_WELCOME (001) is received or with an error if
+// the server rejects the connection or the context is done first. Pings
+// received while waiting are answered.
+//
+// If Username is taken, the nicknames from NickStrategy are tried in order.
+// When a NickServPassword is set, we authenticate using the Auth method and
+// then reclaim Username with GHOST. Authentication failures are returned as a
+// RegistrationError wrapping ErrAuthFailed.
+func (i *Conn) Register(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		i.SetReadDeadline(deadline)
+	}
+
+	// Unblock the pending read if the context is cancelled.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			i.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+		i.SetReadDeadline(time.Time{})
+	}()
+
+	var alternatives []string
+	attempt := 0
+	sasl := &saslNegotiator{conn: i, mechanism: i.saslMechanism()}
+	useSASL := i.useSASL()
+
+	err := i.readUntil(ctx, func(msg *Message) (bool, error) {
+		if useSASL {
+			if handled, err := sasl.handle(msg); handled || err != nil {
+				return false, err
+			}
+		}
+
+		switch msg.Command {
+		case rplWelcome:
+			i.setNick(msg.Param(0))
+			return true, nil
+		case errErroneusNickname:
+			return false, &RegistrationError{Err: ErrErroneousNickname, Code: msg.Command, Reason: msg.Last()}
+		case errNicknameInUse, errNickCollision, errUnavailResource:
+			if alternatives == nil {
+				alternatives = i.alternativeNicks()
+			}
+			if attempt >= len(alternatives) {
+				return false, &RegistrationError{Err: ErrNicknameInUse, Code: msg.Command, Reason: msg.Last()}
+			}
+			i.setNick(alternatives[attempt])
+			i.send("NICK " + alternatives[attempt])
+			attempt++
+		case errYoureBannedCreep:
+			return false, &RegistrationError{Err: ErrBanned, Code: msg.Command, Reason: msg.Last()}
+		case "ERROR":
+			return false, &RegistrationError{Err: ErrServerClosed, Code: msg.Command, Reason: msg.Last()}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if i.Auth == AuthNickServ || (useSASL && !sasl.authenticated) {
+		if i.NickServPassword != "" {
+			if err := i.identify(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if i.Nick() != i.Username && i.NickServPassword != "" {
+		i.reclaimNick(ctx)
+	}
+
+	return nil
+}
+
+// reclaimNick asks NickServ to disconnect whoever is using Username and then
+// switches to it. We keep the alternative nickname if it can't be reclaimed.
+func (i *Conn) reclaimNick(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, reclaimTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	i.SetReadDeadline(deadline)
+
+	i.send("PRIVMSG NickServ :GHOST " + i.Username + " " + i.NickServPassword)
+
+	i.readUntil(ctx, func(msg *Message) (bool, error) {
+		switch {
+		case msg.Command == "NOTICE" && strings.EqualFold(msg.Prefix.Nick, "NickServ"):
+			// Ghost succeeded or failed. Either way, trying the nick again
+			// tells us whether it is free.
+			i.send("NICK " + i.Username)
+		case msg.Command == "NICK" && strings.EqualFold(msg.Prefix.Nick, i.Nick()):
+			i.setNick(msg.Last())
+			return true, nil
+		case msg.Command == errNicknameInUse || msg.Command == errNickCollision || msg.Command == errUnavailResource:
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// readUntil reads messages from the server until handle returns true or an
+// error. Pings are answered automatically.
+func (i *Conn) readUntil(ctx context.Context, handle func(msg *Message) (bool, error)) error {
+	for {
+		line, err := i.readLine()
+		if err != nil {
+			var netErr net.Error
+			if ctx.Err() != nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+				return ErrRegistrationTimeout
+			}
+			return err
+		}
+
+		msg, err := ParseMessage(line)
+		if err != nil {
+			continue
+		}
+
+		if msg.Command == "PING" {
+			i.send("PONG :" + msg.Last())
+			continue
+		}
+
+		done, err := handle(msg)
+		if err != nil || done {
+			return err
+		}
+	}
+}
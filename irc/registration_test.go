@@ -0,0 +1,123 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedConn returns a connection attached to a fake server that sends
+// the given lines and records everything the client writes.
+func scriptedConn(t *testing.T, lines ...string) (*Conn, <-chan string) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	received := make(chan string, 16)
+	go func() {
+		scanner := bufio.NewScanner(server)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+	go func() {
+		for _, line := range lines {
+			server.Write([]byte(line + "\r\n"))
+		}
+	}()
+
+	conn := New("evan_bot", "OpenBooks")
+	conn.attach(client)
+	return conn, received
+}
+
+func TestRegisterWelcome(t *testing.T) {
+	conn, received := scriptedConn(t,
+		":irc.irchighway.net NOTICE * :*** Looking up your hostname...",
+		"PING :3A0F5C21",
+		":irc.irchighway.net 001 evan_bot :Welcome to the IRC Highway IRC Network evan_bot",
+	)
+
+	err := conn.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "PONG :3A0F5C21", <-received)
+}
+
+func TestRegisterErrors(t *testing.T) {
+	cases := []struct {
+		line string
+		want error
+	}{
+		{":irc.irchighway.net 433 * evan_bot :Nickname is already in use.", ErrNicknameInUse},
+		{":irc.irchighway.net 432 * ev@n :Erroneous Nickname", ErrErroneousNickname},
+		{":irc.irchighway.net 465 evan_bot :You are banned from this server- Flooding", ErrBanned},
+		{"ERROR :Closing Link: 127.0.0.1 (Throttled: Reconnecting too fast)", ErrServerClosed},
+	}
+
+	for _, c := range cases {
+		conn, _ := scriptedConn(t, c.line)
+		err := conn.Register(context.Background())
+		assert.ErrorIs(t, err, c.want, c.line)
+
+		var regErr *RegistrationError
+		require.ErrorAs(t, err, &regErr)
+		assert.NotEmpty(t, regErr.Reason)
+	}
+}
+
+func TestRegisterTimeout(t *testing.T) {
+	conn, _ := scriptedConn(t, ":irc.irchighway.net NOTICE * :*** Looking up your hostname...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := conn.Register(ctx)
+	assert.ErrorIs(t, err, ErrRegistrationTimeout)
+}
+
+func TestRegisterNickFallback(t *testing.T) {
+	conn, received := scriptedConn(t,
+		":irc.irchighway.net 433 * evan_bot :Nickname is already in use.",
+		":irc.irchighway.net 433 * evan_reader :Nickname is already in use.",
+		":irc.irchighway.net 001 evan_bot1 :Welcome to the IRC Highway IRC Network evan_bot1",
+	)
+	conn.NickStrategy = ChainNicks(FallbackNicks("evan_reader"), SuffixDigits(2))
+
+	err := conn.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "evan_bot1", conn.Nick())
+	assert.Equal(t, "NICK evan_reader", <-received)
+	assert.Equal(t, "NICK evan_bot1", <-received)
+}
+
+func TestRegisterNickExhausted(t *testing.T) {
+	conn, _ := scriptedConn(t,
+		":irc.irchighway.net 433 * evan_bot :Nickname is already in use.",
+		":irc.irchighway.net 433 * evan_bot1 :Nickname is already in use.",
+	)
+	conn.NickStrategy = SuffixDigits(1)
+
+	err := conn.Register(context.Background())
+	assert.ErrorIs(t, err, ErrNicknameInUse)
+}
+
+func TestRegisterNickGhost(t *testing.T) {
+	conn, received := scriptedConn(t,
+		":irc.irchighway.net 433 * evan_bot :Nickname is already in use.",
+		":irc.irchighway.net 001 evan_bot_ :Welcome to the IRC Highway IRC Network evan_bot_",
+		":NickServ!services@services.irchighway.net NOTICE evan_bot_ :evan_bot has been ghosted.",
+		":evan_bot_!evan_bot@ihw-3a7.example.net NICK :evan_bot",
+	)
+	conn.NickServPassword = "hunter2"
+
+	err := conn.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "evan_bot", conn.Nick())
+	assert.Equal(t, "NICK evan_bot_", <-received)
+	assert.Equal(t, "PRIVMSG NickServ :GHOST evan_bot hunter2", <-received)
+	assert.Equal(t, "NICK evan_bot", <-received)
+}
@@ -0,0 +1,124 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+var ErrFingerprintMismatch = errors.New("certificate fingerprint does not match")
+
+// TLSOptions configures how the server certificate is verified and which
+// client certificate is presented. The zero value verifies the server using
+// the system certificate pool.
+type TLSOptions struct {
+	// Insecure disables certificate verification entirely.
+	Insecure bool
+	// CAFile is a PEM bundle of certificate authorities that are trusted in
+	// addition to the system pool.
+	CAFile string
+	// Fingerprint pins the SHA-256 fingerprint of the server certificate in
+	// hex, with or without colons. The certificate is accepted if it matches,
+	// even if it is self-signed.
+	Fingerprint string
+	// ServerName overrides the host name used for SNI and verification.
+	ServerName string
+	// CertFile and KeyFile hold a PEM client certificate. Servers use it to
+	// identify the account with SASL EXTERNAL or CertFP.
+	CertFile string
+	KeyFile  string
+}
+
+// CertificateError is returned by Connect when the server certificate can't
+// be verified.
+type CertificateError struct {
+	Address string
+	Err     error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("unable to verify the TLS certificate of %s: %s", e.Address, e.Err)
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// config builds the tls.Config used to connect to address.
+func (o TLSOptions) config(address string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.Insecure,
+	}
+
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.Fingerprint != "" {
+		want, err := hex.DecodeString(strings.ReplaceAll(o.Fingerprint, ":", ""))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", o.Fingerprint)
+		}
+		// The pinned fingerprint replaces the usual chain verification.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrFingerprintMismatch
+			}
+			got := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(got[:], want) {
+				return fmt.Errorf("%w: got %s", ErrFingerprintMismatch, hex.EncodeToString(got[:]))
+			}
+			return nil
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// isCertificateError returns true if the handshake failed because the server
+// certificate wasn't trusted.
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.Is(err, ErrFingerprintMismatch)
+}
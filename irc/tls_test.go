@@ -0,0 +1,194 @@
+package irc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned creates a self-signed certificate for localhost and writes the
+// certificate and key to PEM files.
+func selfSigned(t *testing.T, name string) (cert tls.Certificate, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	return cert, certFile, keyFile
+}
+
+// tlsServer accepts TLS connections and reports the client certificates.
+func tlsServer(t *testing.T, cert tls.Certificate) (string, <-chan []*x509.Certificate) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	clients := make(chan []*x509.Certificate, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if tlsConn.Handshake() == nil {
+					clients <- tlsConn.ConnectionState().PeerCertificates
+				}
+				// Keep the connection open until the client hangs up.
+				buf := make([]byte, 512)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), clients
+}
+
+func TestConnectTLS(t *testing.T) {
+	serverCert, caFile, _ := selfSigned(t, "server")
+	_, clientCertFile, clientKeyFile := selfSigned(t, "client")
+	address, clients := tlsServer(t, serverCert)
+
+	sum := sha256.Sum256(serverCert.Certificate[0])
+	fingerprint := hex.EncodeToString(sum[:])
+
+	cases := []struct {
+		reason  string
+		options TLSOptions
+		certErr bool
+	}{
+		{"self-signed is rejected by default", TLSOptions{}, true},
+		{"insecure", TLSOptions{Insecure: true}, false},
+		{"custom CA", TLSOptions{CAFile: caFile}, false},
+		{"custom CA with wrong server name", TLSOptions{CAFile: caFile, ServerName: "irc.irchighway.net"}, true},
+		{"pinned fingerprint", TLSOptions{Fingerprint: fingerprint}, false},
+		{"wrong fingerprint", TLSOptions{Fingerprint: hex.EncodeToString(make([]byte, 32))}, true},
+		{"client certificate", TLSOptions{CAFile: caFile, CertFile: clientCertFile, KeyFile: clientKeyFile}, false},
+	}
+
+	for _, c := range cases {
+		conn := New("evan_bot", "OpenBooks")
+		conn.TLS = c.options
+		err := conn.Connect(address, true)
+
+		if c.certErr {
+			var certErr *CertificateError
+			assert.ErrorAs(t, err, &certErr, c.reason)
+			continue
+		}
+
+		require.NoError(t, err, c.reason)
+		peers := <-clients
+		if c.options.CertFile != "" {
+			require.Len(t, peers, 1, c.reason)
+			assert.Equal(t, "client", peers[0].Subject.CommonName, c.reason)
+		} else {
+			assert.Empty(t, peers, c.reason)
+		}
+		conn.Disconnect()
+	}
+}
+
+func TestConnectContextSilentServer(t *testing.T) {
+	// The server accepts the connection but never answers the TLS handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	conn := New("evan_bot", "OpenBooks")
+	conn.TLS = TLSOptions{Insecure: true}
+	err = conn.ConnectContext(ctx, listener.Addr().String(), true)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTLSOptionsErrors(t *testing.T) {
+	cases := []TLSOptions{
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{Fingerprint: "not hex"},
+		{Fingerprint: "abcd"},
+		{CertFile: "missing.crt", KeyFile: "missing.key"},
+	}
+
+	for _, options := range cases {
+		_, err := options.config("irc.irchighway.net:6697")
+		assert.Error(t, err, options)
+	}
+}
+
+func TestSASLExternal(t *testing.T) {
+	conn, received := scriptedConn(t,
+		":irc.irchighway.net CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL",
+		":irc.irchighway.net CAP * ACK :sasl",
+		"AUTHENTICATE +",
+		":irc.irchighway.net 900 * evan_bot!evan@host evan_bot :You are now logged in as evan_bot",
+		":irc.irchighway.net 903 * :SASL authentication successful",
+		":irc.irchighway.net 001 evan_bot :Welcome to the IRC Highway IRC Network evan_bot",
+	)
+	conn.Auth = AuthExternal
+
+	require.NoError(t, conn.Register(context.Background()))
+	assert.Equal(t, "CAP REQ :sasl", <-received)
+	assert.Equal(t, "AUTHENTICATE EXTERNAL", <-received)
+	assert.Equal(t, "AUTHENTICATE +", <-received)
+	assert.Equal(t, "CAP END", <-received)
+}
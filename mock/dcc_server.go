@@ -5,6 +5,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -12,6 +13,17 @@ type DccServer struct {
 	Port   string
 	Reader io.ReadSeeker
 	log    *log.Logger
+
+	mutex sync.Mutex
+	// offset is where the next transfer starts after a DCC RESUME.
+	offset int64
+}
+
+// Resume accepts a DCC RESUME request. The next transfer starts at position.
+func (dcc *DccServer) Resume(position int64) {
+	dcc.mutex.Lock()
+	defer dcc.mutex.Unlock()
+	dcc.offset = position
 }
 
 func (dcc *DccServer) Start(ready chan<- struct{}) {
@@ -33,6 +45,20 @@ func (dcc *DccServer) Start(ready chan<- struct{}) {
 	}
 }
 
+// Send connects to the receiver of a passive DCC offer and sends the file.
+func (dcc *DccServer) Send(address string) error {
+	if dcc.log == nil {
+		dcc.log = log.New(os.Stdout, "MOCK DCC: ", 0)
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	dcc.handler(conn)
+	return nil
+}
+
 func (dcc *DccServer) handler(conn net.Conn) {
 	defer func() {
 		dcc.Reader.Seek(0, io.SeekStart)
@@ -42,6 +68,19 @@ func (dcc *DccServer) handler(conn net.Conn) {
 
 	dcc.log.Println("Received a connection...")
 
+	dcc.mutex.Lock()
+	offset := dcc.offset
+	dcc.offset = 0
+	dcc.mutex.Unlock()
+
+	if _, err := dcc.Reader.Seek(offset, io.SeekStart); err != nil {
+		dcc.log.Println(err)
+		return
+	}
+	if offset > 0 {
+		dcc.log.Printf("Resuming transfer at byte %d\n", offset)
+	}
+
 	var err error
 	var n int
 	// n, err := io.Copy(conn, dcc.Reader)
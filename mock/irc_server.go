@@ -2,17 +2,26 @@ package mock
 
 import (
 	"bufio"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type IrcServer struct {
 	Port string
-	log  *log.Logger
+	// SASL advertises the sasl capability and accepts SASL PLAIN logins.
+	SASL bool
+	// Accounts maps services account names to passwords. Used for SASL and
+	// NickServ IDENTIFY.
+	Accounts map[string]string
+	// DCC accepts DCC RESUME requests for its transfers when set.
+	DCC *DccServer
+	log *log.Logger
 }
 
 func (irc *IrcServer) Start(ready chan<- struct{}) {
@@ -38,9 +47,10 @@ func (irc *IrcServer) handler(conn net.Conn) {
 	irc.log.Printf("Connection received from %s", conn.RemoteAddr().String())
 	scanner := bufio.NewScanner(conn)
 
-	irc.serverHandler(conn)
-
-	irc.sendVersionRequest(conn)
+	var nick string
+	var sentUser bool
+	// Registration is held until CAP END if the client starts negotiation.
+	var negotiating bool
 
 	for scanner.Scan() {
 		request := scanner.Text()
@@ -50,36 +60,152 @@ func (irc *IrcServer) handler(conn net.Conn) {
 
 		irc.log.Printf("Request Received: %s\n", request)
 
-		if strings.Contains(request, "@search") {
+		switch {
+		case strings.HasPrefix(request, "NICK "):
+			nick = strings.TrimPrefix(request, "NICK ")
+		case strings.HasPrefix(request, "USER "):
+			sentUser = true
+		case strings.HasPrefix(request, "CAP LS"):
+			negotiating = true
+			irc.capListHandler(conn)
+		case strings.HasPrefix(request, "CAP REQ"):
+			irc.capRequestHandler(conn, request)
+		case request == "CAP END":
+			negotiating = false
+		case request == "AUTHENTICATE PLAIN":
+			fmt.Fprint(conn, "AUTHENTICATE +\r\n")
+		case strings.HasPrefix(request, "AUTHENTICATE "):
+			irc.saslHandler(conn, nick, strings.TrimPrefix(request, "AUTHENTICATE "))
+		case strings.HasPrefix(request, "PRIVMSG NickServ :IDENTIFY "):
+			irc.identifyHandler(conn, nick, strings.Fields(strings.TrimPrefix(request, "PRIVMSG NickServ :IDENTIFY ")))
+		case strings.HasPrefix(request, "JOIN "):
+			irc.serverHandler(conn)
+		case strings.Contains(request, "\x01DCC RESUME "):
+			irc.resumeHandler(conn, nick, request)
+		case strings.Contains(request, "@search"):
 			go irc.searchHandler(request, conn)
+		case strings.Contains(request, "!"):
+			go irc.downloadHandler(request, conn)
 		}
 
-		if strings.Contains(request, "!") {
-			go irc.downloadHandler(request, conn)
+		// Registration is complete once both NICK and USER have been sent.
+		if nick != "" && sentUser && !negotiating {
+			irc.welcomeHandler(conn, nick)
+			irc.sendVersionRequest(conn)
+			sentUser = false
 		}
 	}
 
 	irc.log.Println("Connection closed.")
 }
 
+func (irc *IrcServer) capListHandler(conn net.Conn) {
+	caps := "multi-prefix"
+	if irc.SASL {
+		caps += " sasl=PLAIN"
+	}
+	fmt.Fprintf(conn, ":mock.server CAP * LS :%s\r\n", caps)
+}
+
+func (irc *IrcServer) capRequestHandler(conn net.Conn, request string) {
+	if irc.SASL && strings.Contains(request, "sasl") {
+		fmt.Fprint(conn, ":mock.server CAP * ACK :sasl\r\n")
+	} else {
+		fmt.Fprintf(conn, ":mock.server CAP * NAK :%s\r\n", strings.TrimPrefix(request, "CAP REQ :"))
+	}
+}
+
+func (irc *IrcServer) saslHandler(conn net.Conn, nick, payload string) {
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	credentials := strings.Split(string(decoded), "\x00")
+	if err != nil || len(credentials) != 3 || !irc.validLogin(credentials[1], credentials[2]) {
+		irc.log.Println("SASL authentication failed.")
+		fmt.Fprintf(conn, ":mock.server 904 %s :SASL authentication failed\r\n", nick)
+		return
+	}
+
+	irc.log.Printf("SASL authentication succeeded for %s.\n", credentials[1])
+	fmt.Fprintf(conn, ":mock.server 900 %s %s!mock@mock.server %s :You are now logged in as %s\r\n", nick, nick, credentials[1], credentials[1])
+	fmt.Fprintf(conn, ":mock.server 903 %s :SASL authentication successful\r\n", nick)
+}
+
+// identifyHandler accepts "IDENTIFY password" and "IDENTIFY account password".
+func (irc *IrcServer) identifyHandler(conn net.Conn, nick string, args []string) {
+	account := nick
+	if len(args) > 1 {
+		account = args[0]
+	}
+
+	if len(args) == 0 || !irc.validLogin(account, args[len(args)-1]) {
+		irc.log.Println("NickServ IDENTIFY failed.")
+		fmt.Fprintf(conn, ":NickServ!services@services.mock.server NOTICE %s :Invalid password for %s.\r\n", nick, account)
+		return
+	}
+
+	irc.log.Printf("NickServ IDENTIFY succeeded for %s.\n", account)
+	fmt.Fprintf(conn, ":NickServ!services@services.mock.server NOTICE %s :Password accepted - you are now recognized.\r\n", nick)
+}
+
+func (irc *IrcServer) validLogin(account, password string) bool {
+	expected, ok := irc.Accounts[account]
+	return ok && expected == password
+}
+
+func (irc *IrcServer) welcomeHandler(conn net.Conn, nick string) {
+	irc.log.Printf("Welcoming %s.\n", nick)
+	fmt.Fprintf(conn, ":mock.server 001 %s :Welcome to the Mock IRC Network %s\r\n", nick, nick)
+}
+
 func (irc *IrcServer) sendVersionRequest(conn net.Conn) {
 	irc.log.Println("Sending CTCP Version inquiry.")
-	fmt.Fprintf(conn, ":mock_server PRIVMSG evan_28 :\x01VERSION\x01\r\n")
+	fmt.Fprintf(conn, ":Mock!mock@mock.server PRIVMSG evan_28 :\x01VERSION\x01\r\n")
 }
 
 func (irc *IrcServer) serverHandler(conn net.Conn) {
-	fmt.Fprintf(conn, "353 ~DV8 ~Horla +server1 ~server2 ~evan_irc\r\n")
-	fmt.Fprintf(conn, "end_list 366\r\n")
+	fmt.Fprintf(conn, ":mock.server 353 evan_28 = #ebooks :~DV8 ~Horla +server1 ~server2 ~evan_irc\r\n")
+	fmt.Fprintf(conn, ":mock.server 366 evan_28 #ebooks :End of /NAMES list.\r\n")
 }
 
 func (irc *IrcServer) searchHandler(request string, conn net.Conn) {
 	irc.log.Printf("Sending search results.")
-	fmt.Fprint(conn, "NOTICE: Search returned 27 matches\r\n")
-	fmt.Fprint(conn, ":SearchOok!ook@only.ook PRIVMSG evan_28 :DCC SEND SearchOok_results_for__the_great_gatsby.txt.zip 2130706433 6668 1184\r\n")
+	fmt.Fprint(conn, ":SearchOok!ook@only.ook NOTICE evan_28 :Search returned 27 matches\r\n")
+	fmt.Fprint(conn, ":SearchOok!ook@only.ook PRIVMSG evan_28 :\x01DCC SEND SearchOok_results_for__the_great_gatsby.txt.zip 2130706433 6668 1184\x01\r\n")
 }
 
+// resumeHandler answers "DCC RESUME file port position" with DCC ACCEPT.
+func (irc *IrcServer) resumeHandler(conn net.Conn, nick, request string) {
+	if irc.DCC == nil {
+		irc.log.Println("Ignoring DCC RESUME.")
+		return
+	}
+
+	args := request[strings.Index(request, "\x01DCC RESUME ")+len("\x01DCC RESUME "):]
+	args = strings.TrimSuffix(args, "\x01")
+	fields := strings.Fields(args)
+	if len(fields) < 3 {
+		return
+	}
+
+	position, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return
+	}
+	port := fields[len(fields)-2]
+	filename := strings.Join(fields[:len(fields)-2], " ")
+
+	irc.log.Printf("Accepting DCC RESUME at byte %d.\n", position)
+	irc.DCC.Resume(position)
+	fmt.Fprintf(conn, ":SearchOok!ook@only.ook PRIVMSG %s :\x01DCC ACCEPT %s %s %d\x01\r\n", nick, filename, port, position)
+}
+
+// downloadHandler sends the book from the server named in "!Server book".
 func (irc *IrcServer) downloadHandler(request string, conn net.Conn) {
-	irc.log.Println("Sending book file.")
+	server := "DV8"
+	if i := strings.Index(request, ":!"); i >= 0 {
+		server, _, _ = strings.Cut(request[i+2:], " ")
+	}
+
+	irc.log.Printf("Sending book file from %s.\n", server)
 	time.Sleep(time.Second * 4)
-	fmt.Fprint(conn, ":SearchOok!ook@only.ook PRIVMSG evan_28 :DCC SEND great-gatsby.epub 2130706433 6669 358887\r\n")
+	fmt.Fprintf(conn, ":%s!%s@only.ook PRIVMSG evan_28 :\x01DCC SEND great-gatsby.epub 2130706433 6669 358887\x01\r\n", server, server)
 }
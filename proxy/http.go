@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+var ErrHTTPAuthFailed = errors.New("http proxy requires valid credentials")
+
+type httpDialer struct {
+	address  string
+	username string
+	password string
+	forward  Dialer
+}
+
+func (d *httpDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := watchContext(ctx, conn)
+	tunnel, err := d.connect(conn, address)
+	stop()
+
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("http proxy %s: %w", d.address, err)
+	}
+	return tunnel, nil
+}
+
+// connect asks the proxy to open a tunnel to address with a CONNECT request.
+func (d *httpDialer) connect(conn net.Conn, address string) (net.Conn, error) {
+	request := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if d.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.username + ":" + d.password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusProxyAuthRequired:
+		return nil, ErrHTTPAuthFailed
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return nil, fmt.Errorf("connect failed: %s", response.Status)
+	}
+
+	// The server may start talking before we do (IRC servers send notices
+	// right away) so keep whatever was buffered with the response.
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads through the buffered reader used to parse the CONNECT
+// response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
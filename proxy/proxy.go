@@ -0,0 +1,115 @@
+// Package proxy dials TCP connections directly or through a SOCKS5 or HTTP
+// CONNECT proxy. It is shared by the IRC connection and DCC downloads.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrUnsupportedScheme = errors.New("unsupported proxy scheme")
+
+// HandshakeTimeout limits the SOCKS5 and CONNECT handshakes when the context
+// passed to DialContext expires later or not at all. A proxy that accepts
+// the connection but never answers would block forever otherwise.
+var HandshakeTimeout = 30 * time.Second
+
+// Dialer opens TCP connections. *net.Dialer implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Direct connects without a proxy.
+var Direct Dialer = &net.Dialer{}
+
+// FromURL returns a Dialer for a proxy URL. Supported schemes are socks5
+// (host names are resolved locally), socks5h (host names are resolved by the
+// proxy) and http (CONNECT). Credentials are taken from the URL's user info.
+// An empty URL returns Direct.
+func FromURL(raw string) (Dialer, error) {
+	if raw == "" {
+		return Direct, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", raw)
+	}
+
+	var username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return &socks5Dialer{
+			address:       withDefaultPort(u, "1080"),
+			username:      username,
+			password:      password,
+			remoteResolve: u.Scheme == "socks5h",
+			forward:       Direct,
+		}, nil
+	case "http":
+		return &httpDialer{
+			address:  withDefaultPort(u, "80"),
+			username: username,
+			password: password,
+			forward:  Direct,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupportedScheme, u.Scheme)
+}
+
+// Dial connects to address using dialer, or directly if dialer is nil.
+func Dial(ctx context.Context, dialer Dialer, address string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = Direct
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+func withDefaultPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// watchContext interrupts the proxy handshake when the context is done or
+// HandshakeTimeout passed. The returned function stops watching and clears
+// the deadline.
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	deadline := time.Now().Add(HandshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		conn.SetDeadline(time.Time{})
+	}
+}
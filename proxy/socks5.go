@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+var ErrSOCKSAuthFailed = errors.New("socks5 proxy rejected the credentials")
+
+// SOCKS5 protocol constants from RFC 1928 and RFC 1929.
+const (
+	socksVersion        = 0x05
+	socksAuthNone       = 0x00
+	socksAuthPassword   = 0x02
+	socksNoAcceptable   = 0xff
+	socksCmdConnect     = 0x01
+	socksAddrIPv4       = 0x01
+	socksAddrDomain     = 0x03
+	socksAddrIPv6       = 0x04
+	socksPasswordVer    = 0x01
+	socksReplySucceeded = 0x00
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+type socks5Dialer struct {
+	address  string
+	username string
+	password string
+	// remoteResolve sends host names to the proxy instead of resolving them
+	// locally (socks5h).
+	remoteResolve bool
+	forward       Dialer
+}
+
+func (d *socks5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := watchContext(ctx, conn)
+	err = d.handshake(ctx, conn, address)
+	stop()
+
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("socks5 proxy %s: %w", d.address, err)
+	}
+	return conn, nil
+}
+
+func (d *socks5Dialer) handshake(ctx context.Context, conn net.Conn, address string) error {
+	method := byte(socksAuthNone)
+	if d.username != "" {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected protocol version %d", reply[0])
+	}
+	if reply[1] == socksNoAcceptable || reply[1] != method {
+		return errors.New("no acceptable authentication method")
+	}
+
+	if method == socksAuthPassword {
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	request, err := d.connectRequest(ctx, address)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	return readConnectReply(conn)
+}
+
+func (d *socks5Dialer) authenticate(conn net.Conn) error {
+	if len(d.username) > 255 || len(d.password) > 255 {
+		return errors.New("username or password is too long")
+	}
+
+	request := []byte{socksPasswordVer, byte(len(d.username))}
+	request = append(request, d.username...)
+	request = append(request, byte(len(d.password)))
+	request = append(request, d.password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return ErrSOCKSAuthFailed
+	}
+	return nil
+}
+
+// connectRequest builds the CONNECT request for address. Host names are
+// resolved locally unless remoteResolve is set.
+func (d *socks5Dialer) connectRequest(ctx context.Context, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil && !d.remoteResolve {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		ip = addrs[0].IP
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil {
+				ip = addr.IP
+				break
+			}
+		}
+	}
+
+	request := []byte{socksVersion, socksCmdConnect, 0x00}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name %q is too long", host)
+		}
+		request = append(request, socksAddrDomain, byte(len(host)))
+		request = append(request, host...)
+	case ip.To4() != nil:
+		request = append(request, socksAddrIPv4)
+		request = append(request, ip.To4()...)
+	default:
+		request = append(request, socksAddrIPv6)
+		request = append(request, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(request, uint16(port)), nil
+}
+
+// readConnectReply reads the CONNECT reply including the bound address,
+// which we don't need.
+func readConnectReply(conn net.Conn) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != socksReplySucceeded {
+		reason, ok := socksReplies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("unknown error %d", header[1])
+		}
+		return fmt.Errorf("connect failed: %s", reason)
+	}
+
+	var length int
+	switch header[3] {
+	case socksAddrIPv4:
+		length = net.IPv4len
+	case socksAddrIPv6:
+		length = net.IPv6len
+	case socksAddrDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		length = int(size[0])
+	default:
+		return fmt.Errorf("unknown address type %d", header[3])
+	}
+
+	// Bound address followed by the port.
+	_, err := io.ReadFull(conn, make([]byte, length+2))
+	return err
+}
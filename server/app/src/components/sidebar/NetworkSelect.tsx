@@ -0,0 +1,33 @@
+import { Select } from "@mantine/core";
+import { useGetNetworksQuery } from "../../state/api";
+import { useAppSelector } from "../../state/store";
+
+// NetworkSelect switches the network profile the IRC connection uses. The
+// page is reloaded with the "network" query parameter so that the server
+// opens a new connection.
+export default function NetworkSelect() {
+  const { data } = useGetNetworksQuery(null);
+  const network = useAppSelector((store) => store.state.network);
+
+  if (!data || data.networks.length < 2) {
+    return <></>;
+  }
+
+  const selectNetwork = (name: string | null) => {
+    if (!name || name === network) return;
+    const url = new URL(window.location.href);
+    url.searchParams.set("network", name);
+    window.location.assign(url.href);
+  };
+
+  return (
+    <Select
+      size="xs"
+      mt="xs"
+      aria-label="Network"
+      value={network ?? data.default}
+      onChange={selectNetwork}
+      data={data.networks.map((n) => ({ value: n.name, label: n.name }))}
+    />
+  );
+}
@@ -24,6 +24,8 @@ import { toggleSidebar } from "../../state/stateSlice";
 import { useAppDispatch, useAppSelector } from "../../state/store";
 import History from "./History";
 import Library from "./Library";
+import NetworkSelect from "./NetworkSelect";
+import Transfers from "./Transfers";
 
 const useStyles = createStyles((theme, _params, getRef) => {
   return {
@@ -96,6 +98,8 @@ export default function Sidebar() {
           Download eBooks from IRC Highway
         </Text>
 
+        <NetworkSelect />
+
         <SegmentedControl
           size="sm"
           styles={(theme) => ({
@@ -116,6 +120,10 @@ export default function Sidebar() {
         />
       </Navbar.Section>
 
+      <Navbar.Section px="xs">
+        <Transfers />
+      </Navbar.Section>
+
       <Navbar.Section grow p="xs" style={{ overflow: "auto" }}>
         {index === "history" ? <History /> : <Library />}
       </Navbar.Section>
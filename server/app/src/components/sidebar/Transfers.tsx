@@ -0,0 +1,57 @@
+import { Progress, Stack, Text } from "@mantine/core";
+import { ProgressResponse } from "../../state/messages";
+import { useAppSelector } from "../../state/store";
+
+const formatBytes = (bytes: number): string => {
+  const units = ["B", "KB", "MB", "GB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return `${value.toFixed(unit === 0 ? 0 : 1)}${units[unit]}`;
+};
+
+const formatETA = (seconds: number): string => {
+  if (seconds <= 0) return "";
+  if (seconds < 60) return `${seconds}s left`;
+  return `${Math.ceil(seconds / 60)}m left`;
+};
+
+// Transfers shows the progress of the running DCC transfers.
+export default function Transfers() {
+  const transfers = useAppSelector((store) => store.state.transfers);
+  const running = Object.values(transfers);
+
+  if (running.length === 0) {
+    return <></>;
+  }
+
+  return (
+    <Stack spacing="xs">
+      {running.map((transfer) => (
+        <TransferCard key={transfer.name} transfer={transfer} />
+      ))}
+    </Stack>
+  );
+}
+
+function TransferCard({ transfer }: { transfer: ProgressResponse }) {
+  const percent =
+    transfer.size > 0 ? (transfer.received / transfer.size) * 100 : 0;
+
+  return (
+    <div>
+      <Text size="xs" lineClamp={1}>
+        {transfer.name}
+      </Text>
+      <Progress size="sm" value={percent} />
+      <Text size="xs" color="dimmed">
+        {formatBytes(transfer.received)} of {formatBytes(transfer.size)}
+        {transfer.rate > 0 && ` · ${formatBytes(transfer.rate)}/s`}
+        {transfer.eta > 0 && ` · ${formatETA(transfer.eta)}`}
+      </Text>
+    </div>
+  );
+}
@@ -52,7 +52,8 @@ interface BookTableProps {
 
 export default function BookTable({ books }: BookTableProps) {
   const { classes, cx, theme } = useTableStyles();
-  const { data: servers } = useGetServersQuery(null);
+  const network = useSelector((state: RootState) => state.state.network);
+  const { data: servers } = useGetServersQuery(network);
 
   const { ref: elementSizeRef, height, width } = useElementSize();
   const virtualizerRef = useRef();
@@ -15,6 +15,7 @@ import { useVirtualizer } from "@tanstack/react-virtual";
 import { CaretDown, MagnifyingGlass } from "phosphor-react";
 import { CSSProperties, useRef, useState } from "react";
 import { useGetServersQuery } from "../../../state/api";
+import { useAppSelector } from "../../../state/store";
 
 const stringContains = (first: string, second: string): boolean => {
   return first.toLowerCase().includes(second.toLowerCase());
@@ -266,7 +267,8 @@ export function ServerFacetEntry({
   style
 }: FacetEntryProps) {
   const { classes, cx } = useFacetStyles();
-  const { data: servers } = useGetServersQuery(null);
+  const network = useAppSelector((store) => store.state.network);
+  const { data: servers } = useGetServersQuery(network);
   const serverOnline = servers?.includes(entry) ?? false;
 
   return (
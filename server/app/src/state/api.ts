@@ -1,9 +1,24 @@
 import { createApi, fetchBaseQuery } from "@reduxjs/toolkit/query/react";
+import { ServerStatus } from "./messages";
 import { getApiURL } from "./util";
 
 export interface IrcServer {
   elevatedUsers?: string[];
   regularUsers?: string[];
+  servers?: ServerStatus[];
+}
+
+export interface Network {
+  name: string;
+  address: string;
+  tls: boolean;
+  channels: string[];
+  searchBot: string;
+}
+
+export interface NetworkList {
+  default: string;
+  networks: Network[];
 }
 
 export interface Book {
@@ -20,11 +35,16 @@ export const openbooksApi = createApi({
   }),
   tagTypes: ["books", "servers"],
   endpoints: (builder) => ({
-    getServers: builder.query<string[], null>({
-      query: () => `servers`,
+    getServers: builder.query<string[], string | undefined>({
+      query: (network) =>
+        network ? `servers?network=${encodeURIComponent(network)}` : `servers`,
       transformResponse: (ircServers: IrcServer) => {
         return ircServers.elevatedUsers ?? [];
-      }
+      },
+      providesTags: ["servers"]
+    }),
+    getNetworks: builder.query<NetworkList, null>({
+      query: () => `networks`
     }),
     getBooks: builder.query<Book[], null>({
       query: () => `library`,
@@ -40,5 +60,9 @@ export const openbooksApi = createApi({
   })
 });
 
-export const { useGetServersQuery, useGetBooksQuery, useDeleteBookMutation } =
-  openbooksApi;
+export const {
+  useGetServersQuery,
+  useGetNetworksQuery,
+  useGetBooksQuery,
+  useDeleteBookMutation
+} = openbooksApi;
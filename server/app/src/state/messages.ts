@@ -10,7 +10,12 @@ export enum MessageType {
   CONNECT,
   SEARCH,
   DOWNLOAD,
-  RATELIMIT
+  RATELIMIT,
+  DISCONNECTED,
+  RECONNECTING,
+  SERVERS,
+  PROGRESS,
+  EXPIRED
 }
 
 // Notification is used to show a UI toast notification the the user.
@@ -29,10 +34,43 @@ export interface Response extends Omit<Notification, "timestamp"> {
 // ConnectionResponse is received after successful IRC connection
 export interface ConnectionResponse extends Response {
   name: string;
+  network: string;
+}
+
+// ServerStatus is the presence of a download server.
+export interface ServerStatus {
+  name: string;
+  online: boolean;
+  onlineSince: string;
+  lastSeen: string;
+}
+
+// ServersResponse is received when download servers come online or go offline.
+export interface ServersResponse extends Response {
+  network: string;
+  servers: ServerStatus[];
+}
+
+// ProgressResponse is received while a file is transferred from IRC.
+export interface ProgressResponse extends Response {
+  name: string;
+  received: number;
+  size: number;
+  // Bytes per second.
+  rate: number;
+  // Estimated seconds until the transfer completes.
+  eta: number;
+  // Set once the transfer ended, successful or not.
+  done: boolean;
 }
 
 // SearchResponse is received after search results are received and parsed.
 export interface SearchResponse extends Response {
+  // The id sent with the search request.
+  requestId: string;
+  query: string;
+  // The search bot that sent the results.
+  searchBot: string;
   books: BookDetail[];
   errors: ParseError[];
 }
@@ -40,7 +78,19 @@ export interface SearchResponse extends Response {
 // DownloadResponse is received after file is downloaded from IRC and ready for
 // user download.
 export interface DownloadResponse extends Response {
+  // The id sent with the download request.
+  requestId: string;
+  book: string;
   downloadPath?: string;
+  integrity: "verified" | "mismatched" | "not checkable";
+}
+
+// ExpiredResponse is received when a search or download got no answer in time.
+// Either query or book is set.
+export interface ExpiredResponse extends Response {
+  requestId: string;
+  query?: string;
+  book?: string;
 }
 
 export interface BookDetail {
@@ -50,6 +100,7 @@ export interface BookDetail {
   format: string;
   size: string;
   full: string;
+  hash: string;
 }
 
 export interface ParseError {
@@ -10,9 +10,11 @@ import { deleteHistoryItem } from "./historySlice";
 import {
   ConnectionResponse,
   DownloadResponse,
+  ExpiredResponse,
   MessageType,
   Notification,
   NotificationType,
+  ProgressResponse,
   Response,
   SearchResponse
 } from "./messages";
@@ -21,7 +23,9 @@ import {
   removeInFlightDownload,
   sendMessage,
   setConnectionState,
+  setNetwork,
   setSearchResults,
+  setTransferProgress,
   setUsername
 } from "./stateSlice";
 import { AppDispatch, RootState } from "./store";
@@ -35,7 +39,7 @@ export const websocketConn =
   ({ dispatch, getState }: MiddlewareAPI<AppDispatch, RootState>) => {
     const socket = new WebSocket(wsUrl);
 
-    socket.onopen = () => onOpen(dispatch);
+    socket.onopen = () => onOpen(dispatch, getState().state.network);
     socket.onclose = () => onClose(dispatch);
     socket.onmessage = (message) => route(dispatch, message);
     socket.onerror = (event) =>
@@ -63,10 +67,15 @@ export const websocketConn =
     };
   };
 
-const onOpen = (dispatch: AppDispatch): void => {
+const onOpen = (dispatch: AppDispatch, network?: string): void => {
   console.log("WebSocket connected.");
   dispatch(setConnectionState(true));
-  dispatch(sendMessage({ type: MessageType.CONNECT, payload: {} }));
+  dispatch(
+    sendMessage({
+      type: MessageType.CONNECT,
+      payload: { network: network ?? "" }
+    })
+  );
 };
 
 const onClose = (dispatch: AppDispatch): void => {
@@ -75,6 +84,18 @@ const onClose = (dispatch: AppDispatch): void => {
 };
 
 const route = (dispatch: AppDispatch, msg: MessageEvent<any>): void => {
+  // Server presence changes and transfer progress update the UI without a
+  // notification.
+  const update = JSON.parse(msg.data) as Response;
+  if (update.type === MessageType.SERVERS) {
+    dispatch(openbooksApi.util.invalidateTags(["servers"]));
+    return;
+  }
+  if (update.type === MessageType.PROGRESS) {
+    dispatch(setTransferProgress(update as ProgressResponse));
+    return;
+  }
+
   const getNotif = (): Notification => {
     let response = JSON.parse(msg.data) as Response;
     const timestamp = new Date().getTime();
@@ -87,7 +108,9 @@ const route = (dispatch: AppDispatch, msg: MessageEvent<any>): void => {
       case MessageType.STATUS:
         return notification;
       case MessageType.CONNECT:
+        dispatch(setConnectionState(true));
         dispatch(setUsername((response as ConnectionResponse).name));
+        dispatch(setNetwork((response as ConnectionResponse).network));
         return notification;
       case MessageType.SEARCH:
         dispatch(setSearchResults(response as SearchResponse));
@@ -95,11 +118,20 @@ const route = (dispatch: AppDispatch, msg: MessageEvent<any>): void => {
       case MessageType.DOWNLOAD:
         downloadFile((response as DownloadResponse)?.downloadPath);
         dispatch(openbooksApi.util.invalidateTags(["books"]));
-        dispatch(removeInFlightDownload());
+        dispatch(removeInFlightDownload((response as DownloadResponse).book));
         return notification;
       case MessageType.RATELIMIT:
         dispatch(deleteHistoryItem());
         return notification;
+      case MessageType.DISCONNECTED:
+        dispatch(setConnectionState(false));
+        return notification;
+      case MessageType.RECONNECTING:
+        return notification;
+      case MessageType.EXPIRED:
+        // Expired searches are removed from history like rate limited ones.
+        onExpired(dispatch, response as ExpiredResponse);
+        return notification;
       default:
         console.error(response);
         return {
@@ -114,3 +146,11 @@ const route = (dispatch: AppDispatch, msg: MessageEvent<any>): void => {
   dispatch(addNotification(notif));
   displayNotification(notif);
 };
+
+const onExpired = (dispatch: AppDispatch, expired: ExpiredResponse): void => {
+  if (expired.book !== undefined) {
+    dispatch(removeInFlightDownload(expired.book));
+  } else {
+    dispatch(deleteHistoryItem(Number(expired.requestId)));
+  }
+};
@@ -5,7 +5,7 @@ import {
   PayloadAction
 } from "@reduxjs/toolkit";
 import { addHistoryItem, HistoryItem, updateHistoryItem } from "./historySlice";
-import { MessageType, SearchResponse } from "./messages";
+import { MessageType, ProgressResponse, SearchResponse } from "./messages";
 import { AppDispatch, RootState } from "./store";
 
 interface AppState {
@@ -13,7 +13,10 @@ interface AppState {
   isSidebarOpen: boolean;
   activeItem: HistoryItem | null;
   username?: string;
+  network?: string;
   inFlightDownloads: string[];
+  // Running DCC transfers by file name.
+  transfers: Record<string, ProgressResponse>;
 }
 
 const loadActive = (): HistoryItem | null => {
@@ -24,12 +27,19 @@ const loadActive = (): HistoryItem | null => {
   }
 };
 
+// The network profile can be selected with the "network" query parameter.
+// The server's default network is used otherwise.
+const loadNetwork = (): string | undefined =>
+  new URLSearchParams(window.location.search).get("network") ?? undefined;
+
 const initialState: AppState = {
   isConnected: false,
   isSidebarOpen: true,
   activeItem: loadActive(),
   username: undefined,
-  inFlightDownloads: []
+  network: loadNetwork(),
+  inFlightDownloads: [],
+  transfers: {}
 };
 
 const stateSlice = createSlice({
@@ -45,11 +55,25 @@ const stateSlice = createSlice({
     setUsername(state, action: PayloadAction<string>) {
       state.username = action.payload;
     },
+    setNetwork(state, action: PayloadAction<string>) {
+      state.network = action.payload;
+    },
     addInFlightDownload(state, action: PayloadAction<string>) {
       state.inFlightDownloads.push(action.payload);
     },
-    removeInFlightDownload(state) {
-      state.inFlightDownloads.shift();
+    removeInFlightDownload(state, action: PayloadAction<string>) {
+      const index = state.inFlightDownloads.indexOf(action.payload);
+      if (index !== -1) {
+        state.inFlightDownloads.splice(index, 1);
+      }
+    },
+    setTransferProgress(state, action: PayloadAction<ProgressResponse>) {
+      const progress = action.payload;
+      if (progress.done) {
+        delete state.transfers[progress.name];
+      } else {
+        state.transfers[progress.name] = progress;
+      }
     },
     toggleSidebar(state) {
       state.isSidebarOpen = !state.isSidebarOpen;
@@ -69,7 +93,7 @@ const sendDownload = createAsyncThunk(
     dispatch(
       sendMessage({
         type: MessageType.DOWNLOAD,
-        payload: { book }
+        payload: { id: String(new Date().getTime()), book }
       })
     );
   }
@@ -79,18 +103,20 @@ const sendDownload = createAsyncThunk(
 const sendSearch = createAsyncThunk(
   "state/send_sendSearch",
   (queryString: string, { dispatch }) => {
+    // The timestamp identifies the history item the results belong to.
+    const timestamp = new Date().getTime();
+
     // Send the books search query to the server
     dispatch(
       sendMessage({
         type: MessageType.SEARCH,
         payload: {
+          id: String(timestamp),
           query: queryString
         }
       })
     );
 
-    const timestamp = new Date().getTime();
-
     // Add query to item history.
     dispatch(addHistoryItem({ query: queryString, timestamp }));
     dispatch(setActiveItem({ query: queryString, timestamp: timestamp }));
@@ -103,19 +129,29 @@ const setSearchResults = createAsyncThunk<
   { dispatch: AppDispatch; state: RootState }
 >(
   "state/set_search_results",
-  async ({ books, errors }: SearchResponse, { dispatch, getState }) => {
+  async (
+    { requestId, books, errors }: SearchResponse,
+    { dispatch, getState }
+  ) => {
+    // Results of older searches update their own history item.
     const activeItem = getState().state.activeItem;
-    if (activeItem === null) {
+    const item =
+      getState().history.items.find(
+        (x) => String(x.timestamp) === requestId
+      ) ?? activeItem;
+    if (!item) {
       return;
     }
     const updatedItem: HistoryItem = {
-      query: activeItem.query,
-      timestamp: activeItem.timestamp,
+      query: item.query,
+      timestamp: item.timestamp,
       results: books,
       errors: errors
     };
 
-    dispatch(setActiveItem(updatedItem));
+    if (activeItem?.timestamp === item.timestamp) {
+      dispatch(setActiveItem(updatedItem));
+    }
     dispatch(updateHistoryItem(updatedItem));
   }
 );
@@ -124,8 +160,10 @@ export const {
   setActiveItem,
   setConnectionState,
   setUsername,
+  setNetwork,
   addInFlightDownload,
   removeInFlightDownload,
+  setTransferProgress,
   toggleSidebar
 } = stateSlice.actions;
 
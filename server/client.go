@@ -5,6 +5,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/evan-buss/openbooks/core"
 	"github.com/evan-buss/openbooks/irc"
 	"github.com/google/uuid"
 
@@ -41,16 +42,40 @@ type Client struct {
 	// Signal to indicate the connection should be terminated.
 	// disconnect chan struct{}
 
-	// Message to send to the client ws connection
+	// Message to send to the client ws connection. It is never closed, as
+	// searches and IRC events may still write to it. Use respond.
 	send chan interface{}
 
 	// Individual IRC connection per connected client.
 	irc *irc.Conn
 
+	// Searches and downloads over the IRC connection. Set by the CONNECT
+	// request.
+	books *core.Client
+
+	// The network profile the IRC connection uses. Set by the CONNECT request.
+	network core.Network
+
 	log *log.Logger
 
 	// Context is used to signal when this client should close.
 	ctx context.Context
+
+	// Cancels ctx once the client is unregistered.
+	cancel context.CancelFunc
+}
+
+// newIrcConn creates an IRC connection using the configured username,
+// nickname fallbacks and authentication.
+func (server *server) newIrcConn() *irc.Conn {
+	conn := irc.New(server.config.UserName, server.config.UserAgent)
+	conn.NickStrategy = irc.ChainNicks(irc.FallbackNicks(server.config.AltNicks...), irc.SuffixDigits(server.config.NickSuffixes))
+	conn.NickServPassword = server.config.NickServPassword
+	conn.Auth = server.config.Auth
+	conn.Account = server.config.Account
+	conn.TLS = server.config.TLS
+	conn.Dialer = server.config.Dialer
+	return conn
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -100,20 +125,17 @@ func (server *server) writePump(c *Client) {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case message := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
 			err := c.conn.WriteJSON(message)
 			if err != nil {
 				c.log.Printf("Error writing JSON to websocket: %s\n", err)
 				return
 			}
 		case <-c.ctx.Done():
+			// The hub unregistered the client.
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 			return
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
@@ -123,3 +145,12 @@ func (server *server) writePump(c *Client) {
 		}
 	}
 }
+
+// respond queues a message for the websocket connection. The message is
+// dropped once the client has been unregistered.
+func (c *Client) respond(message interface{}) {
+	select {
+	case c.send <- message:
+	case <-c.ctx.Done():
+	}
+}
@@ -1,115 +1,212 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
+	"time"
 
 	"github.com/evan-buss/openbooks/core"
+	"github.com/evan-buss/openbooks/dcc"
 )
 
-func (server *server) NewIrcEventHandler(client *Client) core.EventHandler {
-	handler := core.EventHandler{}
-	handler[core.SearchResult] = client.searchResultHandler(server.config.DownloadDir)
-	handler[core.BookResult] = client.bookResultHandler(server.config.DownloadDir, server.config.DisableBrowserDownloads)
-	handler[core.NoResults] = client.noResultsHandler
-	handler[core.BadServer] = client.badServerHandler
-	handler[core.SearchAccepted] = client.searchAcceptedHandler
-	handler[core.MatchesFound] = client.matchesFoundHandler
-	handler[core.Ping] = client.pingHandler
-	handler[core.ServerList] = client.userListHandler(server.repository)
-	handler[core.Version] = client.versionHandler(server.config.UserAgent)
-	return handler
-}
-
-// searchResultHandler downloads from DCC server, parses data, and sends data to client
-func (c *Client) searchResultHandler(downloadDir string) core.HandlerFunc {
-	return func(text string) {
-		extractedPath, err := core.DownloadExtractDCCString(filepath.Join(downloadDir, "books"), text, nil)
-		if err != nil {
-			c.log.Println(err)
-			c.send <- newErrorResponse("Error when downloading search results.")
-			return
-		}
+// progressInterval limits how often transfer progress is sent to the client.
+const progressInterval = time.Second
+
+// subscribeIrcEvents sends the status updates of the IRC connection to the
+// client
+func (server *server) subscribeIrcEvents(client *Client, events *core.Events) {
+	core.Subscribe(events, client.searchAcceptedHandler)
+	core.Subscribe(events, client.matchesFoundHandler)
+	core.Subscribe(events, client.userListHandler(server.repository))
+	core.Subscribe(events, client.disconnectedHandler)
+	core.Subscribe(events, client.reconnectingHandler)
+	core.Subscribe(events, client.reconnectedHandler)
+	core.Subscribe(events, client.offerRejectedHandler)
+	core.Subscribe(events, client.malformedMessageHandler)
+	core.Subscribe(events, client.readerStoppedHandler)
+	core.Subscribe(events, client.searchUnansweredHandler)
+}
 
-		bookResults, parseErrors, err := core.ParseSearchFile(extractedPath)
-		if err != nil {
-			c.log.Println(err)
-			c.send <- newErrorResponse("Error when parsing search results.")
-			return
-		}
+// search downloads and parses the search results and sends them to the client
+func (c *Client) search(request *SearchRequest) {
+	result, err := c.books.Search(c.ctx, request.Query)
+	switch {
+	case errors.Is(err, core.ErrNoResults):
+		c.noResultsHandler()
+		return
+	case errors.Is(err, core.ErrTimeout):
+		c.respond(newSearchExpiredResponse(request, "The search bot didn't answer. Try again later."))
+		return
+	case errors.Is(err, core.ErrSearchUnanswered):
+		c.respond(newSearchExpiredResponse(request, "No search bot accepted the search. Try again later."))
+		return
+	case core.Declined(err):
+		c.declinedHandler("search results", err)
+		return
+	case err != nil:
+		c.log.Println(err)
+		c.respond(newErrorResponse("Error when downloading search results."))
+		return
+	}
 
-		if len(bookResults) == 0 && len(parseErrors) == 0 {
-			c.noResultsHandler(text)
-			return
-		}
+	if len(result.Books) == 0 && len(result.Errors) == 0 {
+		c.noResultsHandler()
+		return
+	}
 
-		// Output all errors so parser can be improved over time
-		if len(parseErrors) > 0 {
-			c.log.Printf("%d Search Result Parsing Errors\n", len(parseErrors))
-			for _, err := range parseErrors {
-				c.log.Println(err)
-			}
+	// Output all errors so parser can be improved over time
+	if len(result.Errors) > 0 {
+		c.log.Printf("%d Search Result Parsing Errors\n", len(result.Errors))
+		for _, err := range result.Errors {
+			c.log.Println(err)
 		}
+	}
 
-		c.log.Printf("Sending %d search results.\n", len(bookResults))
-		c.send <- newSearchResponse(bookResults, parseErrors)
+	c.log.Printf("Sending %d search results from %s.\n", len(result.Books), result.Bot)
+	c.respond(newSearchResponse(request, result))
+}
 
-		err = os.Remove(extractedPath)
-		if err != nil {
-			c.log.Printf("Error deleting search results file: %v", err)
-		}
+// download downloads the book file and sends it over the websocket
+func (c *Client) download(request *DownloadRequest, disableBrowserDownloads bool) {
+	extractedPath, integrity, err := c.books.DownloadLine(c.ctx, request.Book)
+	switch {
+	case errors.Is(err, core.ErrNoResults):
+		c.respond(newErrorResponse("The server doesn't have that book."))
+		return
+	case errors.Is(err, core.ErrServerUnavailable):
+		c.badServerHandler()
+		return
+	case errors.Is(err, core.ErrTimeout):
+		c.respond(newDownloadExpiredResponse(request))
+		return
+	case core.Declined(err):
+		c.declinedHandler("book", err)
+		return
+	case err != nil:
+		c.log.Println(err)
+		c.respond(newErrorResponse("Error when downloading book."))
+		return
 	}
-}
 
-// bookResultHandler downloads the book file and sends it over the websocket
-func (c *Client) bookResultHandler(downloadDir string, disableBrowserDownloads bool) core.HandlerFunc {
-	return func(text string) {
-		extractedPath, err := core.DownloadExtractDCCString(filepath.Join(downloadDir, "books"), text, nil)
-		if err != nil {
-			c.log.Println(err)
-			c.send <- newErrorResponse("Error when downloading book.")
-			return
-		}
+	c.log.Printf("Sending book entitled '%s' (integrity %s).\n", filepath.Base(extractedPath), integrity)
+	c.respond(newDownloadResponse(request, extractedPath, integrity, disableBrowserDownloads))
+}
 
-		c.log.Printf("Sending book entitled '%s'.\n", filepath.Base(extractedPath))
-		c.send <- newDownloadResponse(extractedPath, disableBrowserDownloads)
+// trackProgress returns a writer that sends the progress of the DCC transfer
+// to the client. Call done once the transfer ended, successful or not.
+func (c *Client) trackProgress(download dcc.Download) (progress io.Writer, done func()) {
+	writer := dcc.NewProgressWriter(download.Filename, download.Size, progressInterval, func(progress dcc.Progress) {
+		c.respond(newProgressResponse(progress, false))
+	})
+	return writer, func() {
+		c.respond(newProgressResponse(dcc.Progress{Filename: download.Filename, Size: download.Size}, true))
 	}
 }
 
 // NoResults is called when the server returns that nothing was found for the query
-func (c *Client) noResultsHandler(_ string) {
-	c.send <- newErrorResponse("No results found for the query.")
+func (c *Client) noResultsHandler() {
+	c.respond(newErrorResponse("No results found for the query."))
 }
 
 // BadServer is called when the requested download fails because the server is not available
-func (c *Client) badServerHandler(_ string) {
-	c.send <- newErrorResponse("Server is not available. Try another one.")
+func (c *Client) badServerHandler() {
+	c.respond(newErrorResponse("Server is not available. Try another one."))
 }
 
 // SearchAccepted is called when the user's query is accepted into the search queue
-func (c *Client) searchAcceptedHandler(_ string) {
-	c.send <- newStatusResponse(NOTIFY, "Search accepted into the queue.")
+func (c *Client) searchAcceptedHandler(_ core.SearchAccepted) {
+	c.respond(newStatusResponse(NOTIFY, "Search accepted into the queue."))
 }
 
 // MatchesFound is called when the server finds matches for the user's query
-func (c *Client) matchesFoundHandler(num string) {
-	c.send <- newStatusResponse(NOTIFY, fmt.Sprintf("Found %s results for your query.", num))
+func (c *Client) matchesFoundHandler(matches core.MatchesFound) {
+	c.respond(newStatusResponse(NOTIFY, fmt.Sprintf("Found %d results for your query.", matches.Count)))
 }
 
-func (c *Client) pingHandler(serverUrl string) {
-	c.irc.Pong(serverUrl)
+func (c *Client) userListHandler(repo *Repository) func(core.ServerList) {
+	return func(list core.ServerList) {
+		changed := repo.SetServers(c.network.Name, list.IrcServers)
+		if len(changed) > 0 {
+			c.respond(newServersResponse(c.network.Name, changed))
+		}
+	}
 }
 
-func (c *Client) versionHandler(version string) core.HandlerFunc {
-	return func(line string) {
-		c.log.Printf("Sending CTCP version response: %s", line)
-		core.SendVersionInfo(c.irc, line, version)
-	}
+// disconnectedHandler is called when the IRC connection drops
+func (c *Client) disconnectedHandler(event core.Disconnected) {
+	c.log.Printf("IRC connection lost: %s\n", event.Reason)
+	c.respond(StatusResponse{
+		MessageType:      DISCONNECTED,
+		NotificationType: DANGER,
+		Title:            "Lost connection to the IRC server.",
+		Detail:           event.Reason,
+	})
+}
+
+// offerRejectedHandler is called when a DCC offer is ignored because it
+// wasn't requested
+func (c *Client) offerRejectedHandler(offer core.OfferRejected) {
+	c.log.Println(offer)
+	c.respond(StatusResponse{
+		MessageType:      STATUS,
+		NotificationType: WARNING,
+		Title:            "Ignored an unexpected file offer.",
+		Detail:           offer.String(),
+	})
+}
+
+// declinedHandler tells the client why an offer was declined before the
+// transfer started
+func (c *Client) declinedHandler(what string, reason error) {
+	c.log.Printf("Declined %s: %v\n", what, reason)
+	c.respond(StatusResponse{
+		MessageType:      STATUS,
+		NotificationType: WARNING,
+		Title:            fmt.Sprintf("Declined the %s.", what),
+		Detail:           reason.Error(),
+	})
+}
+
+// reconnectingHandler is called before each attempt to restore the connection
+func (c *Client) reconnectingHandler(status core.Reconnecting) {
+	c.respond(StatusResponse{
+		MessageType:      RECONNECTING,
+		NotificationType: WARNING,
+		Title:            "Reconnecting to the IRC server.",
+		Detail:           status.String(),
+	})
+}
+
+// reconnectedHandler is called once the connection has been restored
+func (c *Client) reconnectedHandler(event core.Reconnected) {
+	c.log.Println("IRC connection restored.")
+	c.respond(newConnectionResponse("Reconnected to the IRC server.", event.Nick, c.network.Name))
+}
+
+// searchUnansweredHandler is called when a search bot didn't accept the
+// search in time
+func (c *Client) searchUnansweredHandler(event core.SearchUnanswered) {
+	c.log.Println(event)
+	c.respond(StatusResponse{
+		MessageType:      STATUS,
+		NotificationType: WARNING,
+		Title:            "The search bot didn't answer.",
+		Detail:           event.String(),
+	})
+}
+
+// malformedMessageHandler logs lines that aren't valid IRC messages
+func (c *Client) malformedMessageHandler(msg core.MalformedMessage) {
+	c.log.Printf("Skipped malformed IRC message %q: %v\n", msg.Line, msg.Err)
 }
 
-func (c *Client) userListHandler(repo *Repository) core.HandlerFunc {
-	return func(text string) {
-		repo.servers = core.ParseServers(text)
+// readerStoppedHandler is called once no more IRC events will arrive
+func (c *Client) readerStoppedHandler(event core.ReaderStopped) {
+	if event.Err != nil {
+		c.log.Printf("IRC reader stopped: %v\n", event.Err)
+		return
 	}
+	c.log.Println("IRC reader stopped.")
 }
@@ -7,6 +7,7 @@ import (
 	"path"
 
 	"github.com/evan-buss/openbooks/core"
+	"github.com/evan-buss/openbooks/dcc"
 )
 
 //go:generate stringer -type=MessageType
@@ -19,6 +20,11 @@ const (
 	SEARCH
 	DOWNLOAD
 	RATELIMIT
+	DISCONNECTED
+	RECONNECTING
+	SERVERS
+	PROGRESS
+	EXPIRED
 )
 
 type NotificationType int
@@ -43,37 +49,84 @@ type Request struct {
 	Payload     json.RawMessage `json:"payload"`
 }
 
-// ConnectionRequest is a request to start the IRC server
-type ConnectionRequest struct{}
+// ConnectionRequest is a request to start the IRC server. Network is the name
+// of the network profile to use. The default profile is used if it is empty.
+type ConnectionRequest struct {
+	Network string `json:"network"`
+}
 
 // SearchRequest is a request that sends a search request to the IRC server for a specific query
 type SearchRequest struct {
+	// ID is chosen by the client and echoed in the response.
+	ID    string `json:"id"`
 	Query string `json:"query"`
 }
 
 // DownloadRequest is a request to download a specific book from the IRC server
 type DownloadRequest struct {
+	// ID is chosen by the client and echoed in the response.
+	ID   string `json:"id"`
 	Book string `json:"book"`
 }
 
-// ConnectionResponse
+// ConnectionResponse is sent once the IRC connection is established
 type ConnectionResponse struct {
 	StatusResponse
-	Name string `json:"name"`
+	Name    string `json:"name"`
+	Network string `json:"network"`
+}
+
+// ServersResponse is sent when download servers come online or go offline
+type ServersResponse struct {
+	StatusResponse
+	Network string         `json:"network"`
+	Servers []ServerStatus `json:"servers"`
+}
+
+// ProgressResponse reports the progress of a running DCC transfer
+type ProgressResponse struct {
+	StatusResponse
+	Name     string `json:"name"`
+	Received int64  `json:"received"`
+	Size     int64  `json:"size"`
+	// Rate is in bytes per second.
+	Rate float64 `json:"rate"`
+	// ETA is the estimated number of seconds until the transfer completes.
+	ETA float64 `json:"eta"`
+	// Done is set once the transfer ended, successful or not.
+	Done bool `json:"done"`
 }
 
 // SearchResponse is a response that is sent containing BookDetails objects that matched the query
 type SearchResponse struct {
 	StatusResponse
-	Books  []core.BookDetail `json:"books"`
-	Errors []core.ParseError `json:"errors"`
+	RequestID string `json:"requestId"`
+	Query     string `json:"query"`
+	// SearchBot is the nick of the bot that sent the results.
+	SearchBot string            `json:"searchBot"`
+	Books     []core.BookDetail `json:"books"`
+	Errors    []core.ParseError `json:"errors"`
 }
 
 // DownloadResponse is a response that sends the requested book to the client
 type DownloadResponse struct {
 	StatusResponse
+	RequestID    string `json:"requestId"`
+	Book         string `json:"book"`
 	Name         string `json:"name"`
 	DownloadPath string `json:"downloadPath"`
+	// Integrity is the result of comparing the file with the hash listed in
+	// the search results.
+	Integrity core.Integrity `json:"integrity"`
+}
+
+// ExpiredResponse is sent when a search or download got no answer before the
+// timeout. Either Query or Book is set.
+type ExpiredResponse struct {
+	StatusResponse
+	RequestID string `json:"requestId"`
+	Query     string `json:"query,omitempty"`
+	Book      string `json:"book,omitempty"`
 }
 
 func newRateLimitResponse(remainingSeconds float64) StatusResponse {
@@ -91,24 +144,27 @@ func newRateLimitResponse(remainingSeconds float64) StatusResponse {
 	}
 }
 
-func newSearchResponse(results []core.BookDetail, errors []core.ParseError) SearchResponse {
-	detail := fmt.Sprintf("There were %v parsing errors.", len(errors))
-	if len(errors) == 1 {
+func newSearchResponse(request *SearchRequest, result core.SearchResult) SearchResponse {
+	detail := fmt.Sprintf("There were %v parsing errors.", len(result.Errors))
+	if len(result.Errors) == 1 {
 		detail = "There was 1 parsing error."
 	}
 	return SearchResponse{
 		StatusResponse: StatusResponse{
 			MessageType:      SEARCH,
 			NotificationType: SUCCESS,
-			Title:            fmt.Sprintf("%v Search Results Received", len(results)),
+			Title:            fmt.Sprintf("%v Search Results Received", len(result.Books)),
 			Detail:           detail,
 		},
-		Books:  results,
-		Errors: errors,
+		RequestID: request.ID,
+		Query:     request.Query,
+		SearchBot: result.Bot,
+		Books:     result.Books,
+		Errors:    result.Errors,
 	}
 }
 
-func newDownloadResponse(filePath string, disableBrowserDownloads bool) DownloadResponse {
+func newDownloadResponse(request *DownloadRequest, filePath string, integrity core.Integrity, disableBrowserDownloads bool) DownloadResponse {
 	// If we don't want to autodownload the file, show the user the path to the file
 	// otherwise just show file name.
 	if !disableBrowserDownloads {
@@ -122,6 +178,15 @@ func newDownloadResponse(filePath string, disableBrowserDownloads bool) Download
 			Title:            "Book file received.",
 			Detail:           filePath,
 		},
+		RequestID: request.ID,
+		Book:      request.Book,
+		Integrity: integrity,
+	}
+
+	// The file is still delivered. The user decides whether to keep it.
+	if integrity == core.IntegrityMismatched {
+		response.NotificationType = WARNING
+		response.Title = "Book file received, but it doesn't match the listed hash."
 	}
 
 	// If we want to autodownload the file, add the path to the response
@@ -133,6 +198,73 @@ func newDownloadResponse(filePath string, disableBrowserDownloads bool) Download
 	return response
 }
 
+func newConnectionResponse(title string, name string, network string) ConnectionResponse {
+	return ConnectionResponse{
+		StatusResponse: StatusResponse{
+			MessageType:      CONNECT,
+			NotificationType: SUCCESS,
+			Title:            title,
+			Detail:           fmt.Sprintf("IRC username %s on %s", name, network),
+		},
+		Name:    name,
+		Network: network,
+	}
+}
+
+func newServersResponse(network string, servers []ServerStatus) ServersResponse {
+	return ServersResponse{
+		StatusResponse: StatusResponse{
+			MessageType:      SERVERS,
+			NotificationType: NOTIFY,
+			Title:            "Download servers changed.",
+		},
+		Network: network,
+		Servers: servers,
+	}
+}
+
+func newProgressResponse(progress dcc.Progress, done bool) ProgressResponse {
+	return ProgressResponse{
+		StatusResponse: StatusResponse{
+			MessageType:      PROGRESS,
+			NotificationType: NOTIFY,
+			Title:            fmt.Sprintf("Downloading %s.", progress.Filename),
+		},
+		Name:     progress.Filename,
+		Received: progress.Received,
+		Size:     progress.Size,
+		Rate:     math.Round(progress.Rate),
+		ETA:      math.Round(progress.ETA.Seconds()),
+		Done:     done,
+	}
+}
+
+func newSearchExpiredResponse(request *SearchRequest, title string) ExpiredResponse {
+	return ExpiredResponse{
+		StatusResponse: StatusResponse{
+			MessageType:      EXPIRED,
+			NotificationType: DANGER,
+			Title:            title,
+			Detail:           request.Query,
+		},
+		RequestID: request.ID,
+		Query:     request.Query,
+	}
+}
+
+func newDownloadExpiredResponse(request *DownloadRequest) ExpiredResponse {
+	return ExpiredResponse{
+		StatusResponse: StatusResponse{
+			MessageType:      EXPIRED,
+			NotificationType: DANGER,
+			Title:            "The server didn't send the book. Try again later.",
+			Detail:           request.Book,
+		},
+		RequestID: request.ID,
+		Book:      request.Book,
+	}
+}
+
 func newStatusResponse(notificationType NotificationType, title string) StatusResponse {
 	return StatusResponse{
 		MessageType:      STATUS,
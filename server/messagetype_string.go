@@ -13,11 +13,16 @@ func _() {
 	_ = x[SEARCH-2]
 	_ = x[DOWNLOAD-3]
 	_ = x[RATELIMIT-4]
+	_ = x[DISCONNECTED-5]
+	_ = x[RECONNECTING-6]
+	_ = x[SERVERS-7]
+	_ = x[PROGRESS-8]
+	_ = x[EXPIRED-9]
 }
 
-const _MessageType_name = "STATUSCONNECTSEARCHDOWNLOADRATELIMIT"
+const _MessageType_name = "STATUSCONNECTSEARCHDOWNLOADRATELIMITDISCONNECTEDRECONNECTINGSERVERSPROGRESSEXPIRED"
 
-var _MessageType_index = [...]uint8{0, 6, 13, 19, 27, 36}
+var _MessageType_index = [...]uint8{0, 6, 13, 19, 27, 36, 48, 60, 67, 75, 82}
 
 func (i MessageType) String() string {
 	if i < 0 || i >= MessageType(len(_MessageType_index)-1) {
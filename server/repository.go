@@ -1,11 +1,106 @@
 package server
 
-import "github.com/evan-buss/openbooks/core"
+import (
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/evan-buss/openbooks/core"
+)
+
+// ServerStatus is the presence of a download server.
+type ServerStatus struct {
+	Name   string `json:"name"`
+	Online bool   `json:"online"`
+	// OnlineSince is when the server was first seen in its current session.
+	OnlineSince time.Time `json:"onlineSince"`
+	// LastSeen is the last time the server was seen in the channel.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Repository holds the list of users in the channels of each network and
+// tracks when the download servers come and go.
 type Repository struct {
-	servers core.IrcServers
+	mutex    sync.Mutex
+	servers  map[string]core.IrcServers
+	presence map[string]map[string]*ServerStatus
 }
 
 func NewRepository() *Repository {
-	return &Repository{servers: core.IrcServers{}}
+	return &Repository{
+		servers:  make(map[string]core.IrcServers),
+		presence: make(map[string]map[string]*ServerStatus),
+	}
+}
+
+// Servers returns the users of the named network.
+func (r *Repository) Servers(network string) core.IrcServers {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.servers[network]
+}
+
+// SetServers replaces the users of the named network. It returns the download
+// servers that came online or went offline.
+func (r *Repository) SetServers(network string, servers core.IrcServers) []ServerStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.servers[network] = servers
+
+	presence, ok := r.presence[network]
+	if !ok {
+		presence = make(map[string]*ServerStatus)
+		r.presence[network] = presence
+	}
+
+	now := time.Now()
+	online := make(map[string]struct{}, len(servers.ElevatedUsers))
+	changed := make([]ServerStatus, 0)
+
+	for _, name := range servers.ElevatedUsers {
+		online[name] = struct{}{}
+		status, ok := presence[name]
+		if !ok {
+			status = &ServerStatus{Name: name}
+			presence[name] = status
+		}
+		if !status.Online {
+			status.Online = true
+			status.OnlineSince = now
+			changed = append(changed, *status)
+		}
+		status.LastSeen = now
+	}
+
+	for name, status := range presence {
+		if _, ok := online[name]; ok || !status.Online {
+			continue
+		}
+		status.Online = false
+		status.LastSeen = now
+		changed = append(changed, *status)
+	}
+
+	sortStatuses(changed)
+	return changed
+}
+
+// Presence returns every download server that has been seen on the named
+// network, sorted by name.
+func (r *Repository) Presence(network string) []ServerStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	statuses := make([]ServerStatus, 0, len(r.presence[network]))
+	for _, status := range r.presence[network] {
+		statuses = append(statuses, *status)
+	}
+	sortStatuses(statuses)
+	return statuses
+}
+
+func sortStatuses(statuses []ServerStatus) {
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
 }
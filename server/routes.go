@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/evan-buss/openbooks/irc"
 	"io/fs"
 	"log"
 	"net/http"
@@ -17,6 +16,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/evan-buss/openbooks/core"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
@@ -30,12 +30,15 @@ func (server *server) registerRoutes() *chi.Mux {
 	router.Get("/ws", server.serveWs())
 	router.Get("/stats", server.statsHandler())
 	router.Get("/servers", server.serverListHandler())
+	router.Get("/networks", server.networkListHandler())
+	router.Get("/throttle", server.throttleHandler())
 
 	router.Group(func(r chi.Router) {
 		r.Use(server.requireUser)
 		r.Get("/library", server.getAllBooksHandler())
 		r.Delete("/library/{fileName}", server.deleteBooksHandler())
 		r.Get("/library/*", server.getBookHandler())
+		r.Put("/throttle", server.setThrottleHandler())
 	})
 
 	return router
@@ -77,13 +80,15 @@ func (server *server) serveWs() http.HandlerFunc {
 			return
 		}
 
+		ctx, cancel := context.WithCancel(context.Background())
 		client := &Client{
-			conn: conn,
-			send: make(chan interface{}, 128),
-			uuid: userId,
-			irc:  irc.New(server.config.UserName, server.config.UserAgent),
-			log:  log.New(os.Stdout, fmt.Sprintf("CLIENT (%s): ", server.config.UserName), log.LstdFlags|log.Lmsgprefix),
-			ctx:  context.Background(),
+			conn:   conn,
+			send:   make(chan interface{}, 128),
+			uuid:   userId,
+			irc:    server.newIrcConn(),
+			log:    log.New(os.Stdout, fmt.Sprintf("CLIENT (%s): ", server.config.UserName), log.LstdFlags|log.Lmsgprefix),
+			ctx:    ctx,
+			cancel: cancel,
 		}
 
 		server.log.Printf("Client connected from %s\n", conn.RemoteAddr().String())
@@ -109,9 +114,10 @@ func (server *server) staticFilesHandler(assetPath string) http.Handler {
 
 func (server *server) statsHandler() http.HandlerFunc {
 	type statsReponse struct {
-		UUID string `json:"uuid"`
-		IP   string `json:"ip"`
-		Name string `json:"name"`
+		UUID    string `json:"uuid"`
+		IP      string `json:"ip"`
+		Name    string `json:"name"`
+		Network string `json:"network"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -119,9 +125,10 @@ func (server *server) statsHandler() http.HandlerFunc {
 
 		for _, client := range server.clients {
 			details := statsReponse{
-				UUID: client.uuid.String(),
-				Name: client.irc.Username,
-				IP:   client.conn.RemoteAddr().String(),
+				UUID:    client.uuid.String(),
+				Name:    client.irc.Nick(),
+				IP:      client.conn.RemoteAddr().String(),
+				Network: client.network.Name,
 			}
 
 			result = append(result, details)
@@ -131,9 +138,78 @@ func (server *server) statsHandler() http.HandlerFunc {
 	}
 }
 
+// serverListHandler returns the users of the network given by the "network"
+// query parameter, or of the default network.
 func (server *server) serverListHandler() http.HandlerFunc {
+	type serversResponse struct {
+		core.IrcServers
+		Servers []ServerStatus `json:"servers"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		network := r.URL.Query().Get("network")
+		if network == "" {
+			network = server.config.Network
+		}
+		json.NewEncoder(w).Encode(serversResponse{
+			IrcServers: server.repository.Servers(network),
+			Servers:    server.repository.Presence(network),
+		})
+	}
+}
+
+// networkListHandler returns the available network profiles.
+func (server *server) networkListHandler() http.HandlerFunc {
+	type networksResponse struct {
+		Default  string         `json:"default"`
+		Networks []core.Network `json:"networks"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := networksResponse{Default: server.config.Network}
+		for _, name := range server.config.Networks.Names() {
+			response.Networks = append(response.Networks, server.config.Networks[name])
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// throttleHandler returns the DCC bandwidth limits and throughput.
+func (server *server) throttleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(server.config.DCC.Throttle.Stats())
+	}
+}
+
+// setThrottleHandler changes the DCC bandwidth limits of new and running
+// transfers. The limits are in bytes per second and zero is unlimited. The
+// limits are shared by all users, so changes must be allowed with
+// AllowThrottleChanges.
+func (server *server) setThrottleHandler() http.HandlerFunc {
+	type limitsRequest struct {
+		Limit         int64 `json:"limit"`
+		TransferLimit int64 `json:"transferLimit"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(server.repository.servers)
+		if !server.config.AllowThrottleChanges {
+			http.Error(w, "Changing the DCC bandwidth limits is disabled. Start the server with --allow-throttle-changes.", http.StatusForbidden)
+			return
+		}
+
+		var limits limitsRequest
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil || limits.Limit < 0 || limits.TransferLimit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		throttle := server.config.DCC.Throttle
+		throttle.SetLimits(limits.Limit, limits.TransferLimit)
+		server.log.Printf("DCC limits set to %d B/s overall and %d B/s per transfer.\n", limits.Limit, limits.TransferLimit)
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(throttle.Stats())
 	}
 }
 
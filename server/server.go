@@ -11,6 +11,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/evan-buss/openbooks/core"
+	"github.com/evan-buss/openbooks/dcc"
+	"github.com/evan-buss/openbooks/irc"
+	"github.com/evan-buss/openbooks/proxy"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
@@ -35,11 +39,12 @@ type server struct {
 
 	log *log.Logger
 
-	// Mutex to guard the lastSearch timestamp
+	// Mutex to guard the lastSearch timestamps
 	lastSearchMutex sync.Mutex
 
-	// The time the last search was performed. Used to rate limit searches.
-	lastSearch time.Time
+	// The time the last search was performed on each network. Used to rate
+	// limit searches.
+	lastSearch map[string]time.Time
 }
 
 // Config contains settings for server
@@ -47,20 +52,34 @@ type Config struct {
 	Log                     bool
 	Port                    string
 	UserName                string
+	AltNicks                []string
+	NickSuffixes            int
+	NickServPassword        string
+	Auth                    irc.AuthMethod
+	Account                 string
 	Persist                 bool
 	DownloadDir             string
 	Basepath                string
-	Server                  string
-	EnableTLS               bool
-	SearchTimeout           time.Duration
-	SearchBot               string
+	Networks                core.Networks
+	Network                 string
+	TLS                     irc.TLSOptions
+	Dialer                  proxy.Dialer
+	DCC                     dcc.Config
+	SearchInterval          time.Duration
+	SearchAcceptTimeout     time.Duration
 	DisableBrowserDownloads bool
+	AllowThrottleChanges    bool
 	UserAgent               string
 }
 
 func New(config Config) *server {
+	if config.DCC.Throttle == nil {
+		config.DCC.Throttle = dcc.NewThrottle(0, 0)
+	}
+
 	return &server{
 		repository: NewRepository(),
+		lastSearch: make(map[string]time.Time),
 		config:     &config,
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
@@ -81,7 +100,7 @@ func Start(config Config) {
 		AllowCredentials: true,
 		AllowedOrigins:   []string{"http://127.0.0.1:5173"},
 		AllowedHeaders:   []string{"*"},
-		AllowedMethods:   []string{"GET", "DELETE"},
+		AllowedMethods:   []string{"GET", "PUT", "DELETE"},
 	}
 	router.Use(cors.New(corsConfig).Handler)
 
@@ -109,16 +128,12 @@ func (server *server) startClientHub(ctx context.Context) {
 			server.clients[client.uuid] = client
 		case client := <-server.unregister:
 			if _, ok := server.clients[client.uuid]; ok {
-				_, cancel := context.WithCancel(client.ctx)
-				close(client.send)
-				cancel()
+				client.cancel()
 				delete(server.clients, client.uuid)
 			}
 		case <-ctx.Done():
 			for _, client := range server.clients {
-				_, cancel := context.WithCancel(client.ctx)
-				close(client.send)
-				cancel()
+				client.cancel()
 				delete(server.clients, client.uuid)
 			}
 			return
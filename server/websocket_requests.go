@@ -2,7 +2,7 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/evan-buss/openbooks/core"
@@ -19,6 +19,8 @@ func (server *server) routeMessage(message Request, c *Client) {
 	var obj interface{}
 
 	switch message.MessageType {
+	case CONNECT:
+		obj = new(ConnectionRequest)
 	case SEARCH:
 		obj = new(SearchRequest)
 	case DOWNLOAD:
@@ -28,79 +30,103 @@ func (server *server) routeMessage(message Request, c *Client) {
 	err := json.Unmarshal(message.Payload, &obj)
 	if err != nil {
 		server.log.Printf("Invalid request payload. %s.\n", err.Error())
-		c.send <- StatusResponse{
+		c.respond(StatusResponse{
 			MessageType:      STATUS,
 			NotificationType: DANGER,
 			Title:            "Unknown request payload.",
-		}
+		})
 	}
 
 	switch message.MessageType {
 	case CONNECT:
-		c.startIrcConnection(server)
+		c.startIrcConnection(obj.(*ConnectionRequest), server)
 	case SEARCH:
 		c.sendSearchRequest(obj.(*SearchRequest), server)
 	case DOWNLOAD:
-		c.sendDownloadRequest(obj.(*DownloadRequest))
+		c.sendDownloadRequest(obj.(*DownloadRequest), server)
 	default:
 		server.log.Println("Unknown request type received.")
 	}
 }
 
 // handle ConnectionRequests and either connect to the server or do nothing
-func (c *Client) startIrcConnection(server *server) {
-	err := core.Join(c.irc, server.config.Server, server.config.EnableTLS)
+func (c *Client) startIrcConnection(r *ConnectionRequest, server *server) {
+	name := r.Network
+	if name == "" {
+		name = server.config.Network
+	}
+	network, err := server.config.Networks.Get(name)
 	if err != nil {
 		c.log.Println(err)
-		c.send <- newErrorResponse("Unable to connect to IRC server.")
+		response := newErrorResponse("Unknown network.")
+		response.Detail = err.Error()
+		c.respond(response)
 		return
 	}
-
-	handler := server.NewIrcEventHandler(c)
+	c.network = network
+
+	books := core.NewClient(c.irc, core.ClientConfig{
+		Network:             network,
+		DCC:                 server.config.DCC,
+		Dir:                 filepath.Join(server.config.DownloadDir, "books"),
+		Version:             server.config.UserAgent,
+		SearchAcceptTimeout: server.config.SearchAcceptTimeout,
+		Progress:            c.trackProgress,
+	})
+	server.subscribeIrcEvents(c, books.Events())
 
 	if server.config.Log {
 		logger, _, err := util.CreateLogFile(c.irc.Username, server.config.DownloadDir)
 		if err != nil {
 			server.log.Println(err)
 		}
-		handler[core.Message] = func(text string) { logger.Println(text) }
+		core.Subscribe(books.Events(), func(msg core.RawMessage) { logger.Println(msg.Line) })
 	}
 
-	go core.StartReader(c.ctx, c.irc, handler)
-
-	c.send <- ConnectionResponse{
-		StatusResponse: StatusResponse{
-			MessageType:      CONNECT,
-			NotificationType: SUCCESS,
-			Title:            "Welcome, connection established.",
-			Detail:           fmt.Sprintf("IRC username %s", c.irc.Username),
-		},
-		Name: c.irc.Username,
+	err = books.Connect(c.ctx)
+	if err != nil {
+		c.log.Println(err)
+		response := newErrorResponse("Unable to connect to IRC server.")
+		response.Detail = err.Error()
+		c.respond(response)
+		return
 	}
+	c.books = books
+
+	c.respond(newConnectionResponse("Welcome, connection established.", c.irc.Nick(), network.Name))
 }
 
 // handle SearchRequests and send the query to the book server
 func (c *Client) sendSearchRequest(s *SearchRequest, server *server) {
+	if c.books == nil {
+		c.respond(newErrorResponse("Not connected to an IRC server."))
+		return
+	}
+
 	server.lastSearchMutex.Lock()
 	defer server.lastSearchMutex.Unlock()
 
-	nextAvailableSearch := server.lastSearch.Add(server.config.SearchTimeout)
+	nextAvailableSearch := server.lastSearch[c.network.Name].Add(server.config.SearchInterval)
 
 	if time.Now().Before(nextAvailableSearch) {
 		remainingSeconds := time.Until(nextAvailableSearch).Seconds()
-		c.send <- newRateLimitResponse(remainingSeconds)
+		c.respond(newRateLimitResponse(remainingSeconds))
 
 		return
 	}
+	server.lastSearch[c.network.Name] = time.Now()
 
-	core.SearchBook(c.irc, server.config.SearchBot, s.Query)
-	server.lastSearch = time.Now()
-
-	c.send <- newStatusResponse(NOTIFY, "Search request sent.")
+	go c.search(s)
+	c.respond(newStatusResponse(NOTIFY, "Search request sent."))
 }
 
 // handle DownloadRequests by sending the request to the book server
-func (c *Client) sendDownloadRequest(d *DownloadRequest) {
-	core.DownloadBook(c.irc, d.Book)
-	c.send <- newStatusResponse(NOTIFY, "Download request received.")
+func (c *Client) sendDownloadRequest(d *DownloadRequest, server *server) {
+	if c.books == nil {
+		c.respond(newErrorResponse("Not connected to an IRC server."))
+		return
+	}
+
+	go c.download(d, server.config.DisableBrowserDownloads)
+	c.respond(newStatusResponse(NOTIFY, "Download request received."))
 }
@@ -41,9 +41,21 @@ func ExtractArchive(archivePath string) (string, error) {
 			return archiver.ErrStopWalk
 		}
 
-		newPath = filepath.Join(filepath.Dir(archivePath), f.Name()+".temp")
+		// Entry names are chosen by whoever created the archive.
+		path, err := ConfinedPath(filepath.Dir(archivePath), f.Name())
+		if err != nil {
+			return err
+		}
+		// An entry named like the archive would overwrite it while it is
+		// read. Skip it.
+		if path+".temp" == filepath.Clean(archivePath) {
+			return nil
+		}
+		// Another transfer of the same name may still write to its
+		// temporary file.
+		newPath = UniquePath(path + ".temp")
 
-		out, err := os.Create(newPath)
+		out, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
 		if err != nil {
 			return err
 		}
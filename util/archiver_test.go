@@ -0,0 +1,90 @@
+package util
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractArchiveHostileEntries(t *testing.T) {
+	cases := []struct {
+		entry    string
+		expected string
+	}{
+		{"../../escape.txt", "escape.txt"},
+		{`..\..\escape.txt`, "escape.txt"},
+		{"/etc/escape.txt", "escape.txt"},
+		{"..", "download"},
+		{"\x1b[31mred.txt", "[31mred.txt"},
+	}
+
+	for _, c := range cases {
+		root := t.TempDir()
+		dir := filepath.Join(root, "books")
+		require.NoError(t, os.Mkdir(dir, 0755))
+
+		archivePath := filepath.Join(dir, "great-gatsby.zip.temp")
+		writeZip(t, archivePath, c.entry, "The Great Gatsby")
+
+		path, err := ExtractArchive(archivePath)
+		require.NoError(t, err, c.entry)
+		assert.Equal(t, filepath.Join(dir, c.expected+".temp"), path, c.entry)
+		assert.NoFileExists(t, filepath.Join(root, "escape.txt.temp"), c.entry)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "The Great Gatsby", string(data))
+	}
+}
+
+func TestExtractArchiveEntryNamedLikeArchive(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "great-gatsby.zip.temp")
+	writeZip(t, archivePath, "great-gatsby.zip", "The Great Gatsby")
+
+	path, err := ExtractArchive(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, archivePath, path)
+
+	// The archive is delivered intact.
+	reader, err := zip.OpenReader(archivePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.Len(t, reader.File, 1)
+	assert.Equal(t, "great-gatsby.zip", reader.File[0].Name)
+}
+
+func TestExtractArchiveKeepsOtherTransfers(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "great-gatsby.zip.temp")
+	writeZip(t, archivePath, "great-gatsby.epub", "The Great Gatsby")
+
+	// Another transfer of the same book is still running.
+	running := filepath.Join(dir, "great-gatsby.epub.temp")
+	require.NoError(t, os.WriteFile(running, []byte("partial"), 0644))
+
+	path, err := ExtractArchive(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "great-gatsby (1).epub.temp"), path)
+
+	data, err := os.ReadFile(running)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", string(data))
+}
+
+func writeZip(t *testing.T, path, entry, content string) {
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	out, err := writer.Create(entry)
+	require.NoError(t, err)
+	_, err = out.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package util
+
+// FreeSpace isn't supported on this platform.
+func FreeSpace(dir string) (int64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
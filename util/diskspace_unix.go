@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import "syscall"
+
+// FreeSpace returns the number of bytes available to us on the file system
+// that holds dir.
+func FreeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
@@ -0,0 +1,121 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnsafePath = errors.New("path escapes the download directory")
+)
+
+// maxFilenameLength is the limit of most file systems in bytes, minus room
+// for the ".temp" suffix and collision counters.
+const maxFilenameLength = 200
+
+// fallbackFilename replaces names that are empty once sanitized.
+const fallbackFilename = "download"
+
+// Names Windows reserves for devices, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename turns a file name chosen by someone else, like the sender
+// of a DCC offer or the creator of an archive, into a name that is safe to
+// create in the download directory. Directories are dropped, so traversal
+// and absolute paths end up as plain names. Control characters and
+// characters that aren't allowed on Windows are removed, reserved device
+// names are prefixed and long names are shortened, keeping the extension.
+func SanitizeFilename(name string) string {
+	// Treat both separators as separators, whatever the platform.
+	name = strings.ReplaceAll(name, `\`, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	// Windows drops trailing dots and spaces. Leading dots hide files and
+	// make "." and ".." possible.
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return fallbackFilename
+	}
+
+	base := name
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+
+	return truncateFilename(name, maxFilenameLength)
+}
+
+// truncateFilename shortens name to at most max bytes without splitting a
+// character. The extension is kept if it is short enough.
+func truncateFilename(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) > max/4 {
+		ext = ""
+	}
+
+	base := name[:max-len(ext)]
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return strings.TrimRight(base, ". ") + ext
+}
+
+// ConfinedPath sanitizes name and joins it to dir. It returns ErrUnsafePath
+// if the result isn't directly inside dir.
+func ConfinedPath(dir, name string) (string, error) {
+	path := filepath.Join(dir, SanitizeFilename(name))
+	if filepath.Dir(path) != filepath.Clean(dir) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return path, nil
+}
+
+// UniquePath returns path if nothing exists there. Otherwise a counter is
+// added before the extension ("book (1).epub") until the path is free. For
+// temporary files the counter goes before the real extension
+// ("book (1).epub.temp") so the name stays valid once ".temp" is removed.
+func UniquePath(path string) string {
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+
+	var temp string
+	if filepath.Ext(path) == ".temp" {
+		path, temp = path[:len(path)-len(".temp")], ".temp"
+	}
+	ext := filepath.Ext(path)
+	base := path[:len(path)-len(ext)]
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s%s", base, i, ext, temp)
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+	}
+}
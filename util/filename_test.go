@@ -0,0 +1,73 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		reason   string
+		name     string
+		expected string
+	}{
+		{"regular name", "F Scott Fitzgerald - The Great Gatsby.epub", "F Scott Fitzgerald - The Great Gatsby.epub"},
+		{"parent directory", "../../.bashrc", "bashrc"},
+		{"absolute path", "/etc/cron.d/openbooks", "openbooks"},
+		{"windows path", `C:\Windows\System32\evil.dll`, "evil.dll"},
+		{"windows traversal", `..\..\startup.bat`, "startup.bat"},
+		{"only dots", "..", "download"},
+		{"empty", "", "download"},
+		{"control characters", "The\x00 Great\r\n Gatsby\x1b.epub", "The Great Gatsby.epub"},
+		{"invalid windows characters", `What? <The> "Great" Gatsby|*.epub`, "What The Great Gatsby.epub"},
+		{"reserved name", "CON.txt", "_CON.txt"},
+		{"reserved name without extension", "lpt1", "_lpt1"},
+		{"trailing dots and spaces", "Gatsby.epub. . ", "Gatsby.epub"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, SanitizeFilename(c.name), c.reason)
+	}
+}
+
+func TestSanitizeFilenameLength(t *testing.T) {
+	name := SanitizeFilename(strings.Repeat("Gatsby ", 100) + ".epub")
+	assert.LessOrEqual(t, len(name), maxFilenameLength)
+	assert.True(t, strings.HasSuffix(name, ".epub"), "extension is kept")
+
+	// Multi-byte characters aren't split.
+	name = SanitizeFilename(strings.Repeat("ギャツビー", 50) + ".epub")
+	assert.LessOrEqual(t, len(name), maxFilenameLength)
+	assert.Equal(t, "ギ", string([]rune(name)[0]))
+	assert.True(t, strings.HasSuffix(name, "ー.epub"))
+}
+
+func TestConfinedPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"../escape.txt", "/tmp/escape.txt", `..\escape.txt`, "sub/dir/escape.txt"} {
+		path, err := ConfinedPath(dir, name)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "escape.txt"), path, name)
+	}
+}
+
+func TestUniquePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "great-gatsby.epub")
+	assert.Equal(t, path, UniquePath(path))
+
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	assert.Equal(t, filepath.Join(dir, "great-gatsby (1).epub"), UniquePath(path))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "great-gatsby (1).epub"), nil, 0644))
+	assert.Equal(t, filepath.Join(dir, "great-gatsby (2).epub"), UniquePath(path))
+
+	temp := filepath.Join(dir, "great-gatsby.epub.temp")
+	require.NoError(t, os.WriteFile(temp, nil, 0644))
+	assert.Equal(t, filepath.Join(dir, "great-gatsby (1).epub.temp"), UniquePath(temp))
+}
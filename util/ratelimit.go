@@ -0,0 +1,98 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a rate limiter that refills at a fixed rate of tokens per
+// second and allows bursts of up to burst tokens.
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket. A rate of 0 disables limiting.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the rate and burst size. Tokens collected at the old rate
+// are kept, up to the new burst size. A bucket that wasn't limiting starts
+// full. A rate of 0 disables limiting.
+func (b *TokenBucket) SetRate(rate float64, burst int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	} else {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Rate returns the number of tokens added per second.
+func (b *TokenBucket) Rate() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rate
+}
+
+// Reserve takes n tokens from the bucket and returns how long the caller has
+// to wait before using them. Requests larger than the burst size are allowed
+// but have to wait for the bucket to refill.
+func (b *TokenBucket) Reserve(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait blocks until n tokens are available or the context is done.
+func (b *TokenBucket) Wait(ctx context.Context, n int) error {
+	delay := b.Reserve(n)
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
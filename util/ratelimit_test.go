@@ -0,0 +1,22 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketSetRate(t *testing.T) {
+	bucket := NewTokenBucket(0, 0)
+	assert.Zero(t, bucket.Reserve(1000), "a rate of 0 doesn't limit")
+
+	// Enabling the limit starts with a full bucket.
+	bucket.SetRate(10, 10)
+	assert.Equal(t, 10.0, bucket.Rate())
+	assert.Zero(t, bucket.Reserve(10))
+	assert.InDelta(t, time.Second, bucket.Reserve(10), float64(50*time.Millisecond))
+
+	bucket.SetRate(0, 0)
+	assert.Zero(t, bucket.Reserve(1000))
+}
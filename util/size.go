@@ -0,0 +1,86 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSize          = errors.New("invalid size")
+	ErrFreeSpaceUnsupported = errors.New("free space can't be determined on this platform")
+)
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	// Longer suffixes first so "KiB" isn't read as "B".
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a size like "500MB", "1.5 GiB" or "2048". Units are
+// powers of 1024 like the sizes listed by the download servers. An empty
+// string is zero.
+func ParseSize(text string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(text))
+	if number == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidSize, text)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatSize formats bytes with the largest unit that keeps the number at
+// least 1, e.g. "1.5MB".
+func FormatSize(bytes int64) string {
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if bytes >= unit.bytes {
+			value := strconv.FormatFloat(float64(bytes)/float64(unit.bytes), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
+
+// DirSize returns the total size of the files in dir and its
+// subdirectories.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		text  string
+		bytes int64
+	}{
+		{"", 0},
+		{"2048", 2048},
+		{"512B", 512},
+		{"394.7KB", 404172},
+		{"205.10 KiB", 210022},
+		{"200MB", 200 << 20},
+		{"1.5 GiB", 3 << 29},
+		{"10g", 10 << 30},
+	}
+
+	for _, c := range cases {
+		bytes, err := ParseSize(c.text)
+		require.NoError(t, err, c.text)
+		assert.Equal(t, c.bytes, bytes, c.text)
+	}
+
+	for _, text := range []string{"MB", "ten MB", "-5MB", "5PB"} {
+		_, err := ParseSize(text)
+		assert.ErrorIs(t, err, ErrInvalidSize, text)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512B", FormatSize(512))
+	assert.Equal(t, "1KB", FormatSize(1024))
+	assert.Equal(t, "1.5MB", FormatSize(3<<19))
+	assert.Equal(t, "10GB", FormatSize(10<<30))
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "great-gatsby.epub"), make([]byte, 1000), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "logs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "logs", "evan.log"), make([]byte, 24), 0644))
+
+	size, err := DirSize(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), size)
+}
+
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(t.TempDir())
+	if err == ErrFreeSpaceUnsupported {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	assert.Greater(t, free, int64(0))
+}